@@ -8,19 +8,20 @@ package ccg
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/format"
-	"go/parser"
 	"go/token"
 	"go/types"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 )
 
@@ -31,44 +32,175 @@ var (
 
 type Config struct {
 	// generation options
-	From     string
+	From     string  // import path or relative directory of the template package
+	Dir      string  // directory to resolve From in, default to the current directory
+	Loader   *Loader // shared package loader, FileSet and Dir are taken from it if set
 	Params   map[string]string
 	Renames  map[string]string
+	Args     map[string]string // bound as Params or Renames according to the template, see paramNames
 	Existing []*ast.File
 	FileSet  *token.FileSet
-	Uses     []string
+	Uses     []string // names, methods and patterns of declarations to generate with their dependencies, see parseUses
+
+	// appended to unexported top-level names not renamed explicitly, avoiding collisions between instantiations
+	HelperSuffix string
+
+	// apply rename patterns in Renames, such as *=Int*, to unexported names too
+	RenameUnexported bool
+
+	// prune dead branches of constant conditions and type switches after substitution, Package required
+	Simplify bool
+
+	// emit //line directives mapping generated declarations and statements to the template package, OutputFile required
+	LineDirectives bool
+
+	// generate only declarations of the template file of this base name, and its file comments, see Job.Split.
+	// composed declarations are generated with the main file, see mainFile.
+	TemplateFile string
+
+	// generate only declarations of _test.go files of the template package, in the package under test, for the test file of an instantiation
+	TestFiles bool
 
 	// output options
 	Writer     io.Writer
 	Package    string
 	OutputFile string
+	Verify     bool   // type-check the output with other files in the package of OutputFile before writing
+	Check      bool   // report a *StaleError if OutputFile differs from the output, instead of writing
+	DryRun     bool   // write a unified diff against OutputFile and a summary of declaration changes, instead of the output
+	Header     bool   // emit the generated-code header, provenance comments and ownership markers, and replace only marked declarations
+	Adopt      bool   // with Header set, replace declarations of an existing file with neither provenances nor markers, such as one generated before ownership markers
+	SourceMap  string // path to write the JSON source map of the output to, see Result.SourceMap
 }
 
-func Copy(config Config) (ret error) {
+// Instantiate instantiates the template package as configured, returning the output file and generation details.
+// output options other than Package and OutputFile are not used.
+func Instantiate(config Config) (*Result, error) {
 	// load package
-	loadConf := loader.Config{
-		Fset:       config.FileSet,
-		ParserMode: parser.ParseComments,
+	loader := config.Loader
+	if loader == nil {
+		if config.FileSet == nil {
+			config.FileSet = new(token.FileSet)
+		}
+		loader = NewLoader(config.FileSet, config.Dir)
+	}
+	config.FileSet = loader.FileSet
+	load := loader.Load
+	if config.TestFiles {
+		load = loader.LoadTests
 	}
-	loadConf.Import(config.From)
-	program, err := loadConf.Load()
+	pkg, err := load(config.From)
 	if err != nil {
-		return me(err, "load package")
+		return nil, me(err, "load package")
+	}
+	info := pkg.TypesInfo
+	provenance := Provenance{
+		From:    pkg.PkgPath,
+		Params:  config.Params,
+		Renames: config.Renames,
+		Args:    config.Args,
+		Uses:    config.Uses,
+		Suffix:  config.HelperSuffix,
+
+		RenameUnexported: config.RenameUnexported,
+		Simplify:         config.Simplify,
+		LineDirectives:   config.LineDirectives,
+		File:             config.TemplateFile,
+		TestFiles:        config.TestFiles,
 	}
-	info := program.Imported[config.From]
 
 	// utils functions
 	formatNode := func(node interface{}) (string, error) {
 		buf := new(bytes.Buffer)
-		err := format.Node(buf, loadConf.Fset, node)
+		err := format.Node(buf, config.FileSet, node)
 		if err != nil { //NOCOVER
 			return "", me(err, "format node")
 		}
 		return string(buf.Bytes()), nil
 	}
 
+	// bind args
+	if len(config.Args) > 0 {
+		params := make(map[string]string)
+		renames := make(map[string]string)
+		for from, to := range config.Params {
+			params[from] = to
+		}
+		for from, to := range config.Renames {
+			renames[from] = to
+		}
+		placeholders := paramNames(pkg)
+		for from, to := range config.Args {
+			if placeholders.In(from) || !token.IsIdentifier(to) { // renamed to identifiers only, as functions bound to references are params
+				params[from] = to
+			} else {
+				renames[from] = to
+			}
+		}
+		config.Params = params
+		config.Renames = renames
+	}
+
+	// expand rename patterns
+	params := NewStrSet()
+	for name := range config.Params {
+		params.Add(name)
+	}
+	config.Renames, err = expandRenames(config.Renames, pkg.Types.Scope().Names(), params, config.RenameUnexported)
+	if err != nil {
+		return nil, me(err, "process")
+	}
+
+	// suffix unexported helpers
+	if config.HelperSuffix != "" {
+		renames := make(map[string]string)
+		for from, to := range config.Renames {
+			renames[from] = to
+		}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			if ast.IsExported(name) || name == "_" {
+				continue
+			}
+			if _, ok := config.Params[name]; ok {
+				continue
+			}
+			if _, ok := renames[name]; ok {
+				continue
+			}
+			renames[name] = name + config.HelperSuffix
+		}
+		config.Renames = renames
+	}
+
+	// qualify type and function names in params by import paths
+	dir := loader.Dir
+	if config.OutputFile != "" {
+		dir = filepath.Dir(config.OutputFile)
+	}
+	qualifier := newQualifier(dir, pkg, config.Existing)
+	for _, to := range config.Renames {
+		qualifier.taken.Add(to)
+	}
+	if len(config.Params) > 0 {
+		params := make(map[string]string)
+		for from, to := range config.Params {
+			obj := pkg.Types.Scope().Lookup(from)
+			if !qualifiedType.MatchString(to) || !(obj == nil || isTypeName(obj) || isFuncParam(obj)) { // not a type or function
+				params[from] = to
+				continue
+			}
+			to, err := qualifier.qualify(to)
+			if err != nil {
+				return nil, me(err, "qualify param %s", from)
+			}
+			params[from] = to
+		}
+		config.Params = params
+	}
+
 	// remove param declarations
-	for _, f := range info.Files {
+	for _, f := range pkg.Syntax {
 		f.Decls = filterDecls(f.Decls, func(node interface{}) bool {
 			switch node := node.(type) {
 			case *ast.TypeSpec:
@@ -78,39 +210,183 @@ func Copy(config Config) (ret error) {
 			case valueInfo:
 				_, exists := config.Params[node.Name.Name]
 				return !exists
+			case *ast.FuncDecl:
+				if node.Recv != nil {
+					return true
+				}
+				_, exists := config.Params[node.Name.Name]
+				return !exists
 			}
 			return true
 		})
 	}
 
-	// collect objects to rename
 	renamed := map[string]string{}
 	objects := make(map[types.Object]string)
-	collectObjects := func(mapping map[string]string) error {
+	collectObjects := func(mapping map[string]string, isParams bool) error {
 		for from, to := range mapping {
-			obj := info.Pkg.Scope().Lookup(from)
+			obj := pkg.Types.Scope().Lookup(from)
 			if obj == nil {
 				return fmt.Errorf("name not found %s", from)
 			}
-			if t, ok := obj.Type().(*types.Basic); ok && t.Kind() == types.String {
-				to = "`" + to + "`"
+			switch {
+			case !isParams || isTypeName(obj):
+			case isFuncParam(obj):
+				value, err := funcParam(config.FileSet, pkg, obj, to, config.Params)
+				if err != nil {
+					return me(err, "param %s", from)
+				}
+				to = value
+			default:
+				value, err := valueParam(config.FileSet, pkg, obj, to, config.Renames)
+				if err != nil {
+					return me(err, "param %s", from)
+				}
+				to = value
+			}
+			if !isParams && !token.IsIdentifier(to) {
+				return fmt.Errorf("rename %s to %s: not an identifier", from, to)
 			}
 			objects[obj] = to
 			renamed[to] = from
 		}
 		return nil
 	}
-	if err := collectObjects(config.Params); err != nil {
-		return me(err, "process")
+
+	// bind type parameters of generic declarations
+	specialized := NewObjectSet()
+	typeParamNames := NewStrSet()
+	bindTypeParams := func(obj types.Object, params *types.TypeParamList) error {
+		bound := 0
+		for i := 0; i < params.Len(); i++ {
+			param := params.At(i).Obj()
+			typeParamNames.Add(param.Name())
+			if to, ok := config.Params[param.Name()]; ok {
+				objects[param] = to
+				bound++
+			}
+		}
+		switch bound {
+		case 0: // left generic
+		case params.Len():
+			specialized.Add(obj)
+		default:
+			return fmt.Errorf("type parameters of %s partially bound", obj.Name())
+		}
+		return nil
 	}
-	if err := collectObjects(config.Renames); err != nil {
-		return me(err, "process")
+	var methods []*ast.FuncDecl
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					spec, ok := spec.(*ast.TypeSpec)
+					if !ok || spec.TypeParams == nil {
+						continue
+					}
+					obj := info.Defs[spec.Name]
+					if err := bindTypeParams(obj, typeParamsOf(obj)); err != nil {
+						return nil, me(err, "process")
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv != nil {
+					methods = append(methods, decl)
+					continue
+				}
+				if decl.Type.TypeParams == nil {
+					continue
+				}
+				obj := info.Defs[decl.Name]
+				if err := bindTypeParams(obj, obj.Type().(*types.Signature).TypeParams()); err != nil {
+					return nil, me(err, "process")
+				}
+			}
+		}
+	}
+	for _, decl := range methods {
+		// receiver type parameters are bound by position to the receiver base type's
+		sig := info.Defs[decl.Name].Type().(*types.Signature)
+		recvParams := sig.RecvTypeParams()
+		if recvParams.Len() == 0 {
+			continue
+		}
+		t := sig.Recv().Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		named, ok := types.Unalias(t).(*types.Named)
+		if !ok { //NOCOVER
+			continue
+		}
+		base := named.Origin()
+		if !specialized.In(base.Obj()) {
+			continue
+		}
+		for i := 0; i < recvParams.Len(); i++ {
+			objects[recvParams.At(i).Obj()] = objects[base.TypeParams().At(i).Obj()]
+		}
+	}
+
+	// check instantiations of specialized declarations
+	for id, instance := range info.Instances {
+		obj := originOf(info.Uses[id])
+		if !specialized.In(obj) {
+			continue
+		}
+		params := typeParamsOf(obj)
+		for i := 0; i < instance.TypeArgs.Len(); i++ {
+			arg := instance.TypeArgs.At(i)
+			var argStr string
+			if param, ok := arg.(*types.TypeParam); ok {
+				argStr = objects[param.Obj()]
+			} else {
+				argStr = types.TypeString(arg, types.RelativeTo(pkg.Types))
+			}
+			if argStr != objects[params.At(i).Obj()] {
+				return nil, me(fmt.Errorf("%s instantiated with different type arguments at %v",
+					obj.Name(), config.FileSet.Position(id.Pos())), "process")
+			}
+		}
+	}
+
+	// instantiate composed templates
+	compositions, err := compose(config, loader, pkg, objects)
+	if err != nil {
+		return nil, me(err, "compose")
+	}
+	rewriteComposed(pkg, compositions)
+
+	// check parameter constraints
+	outputDir := ""
+	if config.OutputFile != "" {
+		outputDir = filepath.Dir(config.OutputFile)
+	}
+	resolve := resolveType(config.FileSet, loader, pkg, qualifier.names, outputDir)
+	if err := checkConstraints(config.FileSet, pkg, config.Params, specialized, objects, resolve); err != nil {
+		return nil, me(err, "check params")
+	}
+
+	// collect objects to rename
+	scopeParams := make(map[string]string)
+	for from, to := range config.Params {
+		if typeParamNames.In(from) && pkg.Types.Scope().Lookup(from) == nil {
+			continue
+		}
+		scopeParams[from] = to
+	}
+	if err := collectObjects(scopeParams, true); err != nil {
+		return nil, me(err, "process")
+	}
+	if err := collectObjects(config.Renames, false); err != nil {
+		return nil, me(err, "process")
 	}
 
 	// rename
 	rename := func(defs map[*ast.Ident]types.Object) {
 		for id, obj := range defs {
-			if to, ok := objects[obj]; ok {
+			if to, ok := objects[originOf(obj)]; ok {
 				id.Name = to
 			}
 		}
@@ -118,17 +394,96 @@ func Copy(config Config) (ret error) {
 	rename(info.Defs)
 	rename(info.Uses)
 
+	// strip type parameters and instantiations of specialized declarations
+	for _, f := range pkg.Syntax {
+		astutil.Apply(f, func(cursor *astutil.Cursor) bool {
+			switch node := cursor.Node().(type) {
+			case *ast.TypeSpec:
+				if specialized.In(info.Defs[node.Name]) {
+					node.TypeParams = nil
+				}
+			case *ast.FuncDecl:
+				if specialized.In(info.Defs[node.Name]) {
+					node.Type.TypeParams = nil
+				}
+			case *ast.IndexExpr:
+				if id, ok := node.X.(*ast.Ident); ok && specialized.In(originOf(info.Uses[id])) {
+					cursor.Replace(node.X)
+				}
+			case *ast.IndexListExpr:
+				if id, ok := node.X.(*ast.Ident); ok && specialized.In(originOf(info.Uses[id])) {
+					cursor.Replace(node.X)
+				}
+			}
+			return true
+		}, nil)
+	}
+
+	composed, err := mergeComposed(config.FileSet, pkg, compositions)
+	if err != nil {
+		return nil, me(err, "compose")
+	}
+	composedIdents := make(map[*ast.Ident]bool)
+	for _, f := range composed {
+		for _, decl := range f.Decls {
+			for _, id := range declIdents(decl) {
+				composedIdents[id] = true
+			}
+		}
+	}
+	outputFiles := append(append([]*ast.File(nil), pkg.Syntax...), composed...)
+
+	// declarations of other template files
+	var templateFile *ast.File
+	otherFiles := make(map[*ast.Ident]bool)
+	exclude := func(f *ast.File) {
+		for _, decl := range f.Decls {
+			for _, id := range declIdents(decl) {
+				otherFiles[id] = true
+			}
+		}
+	}
+	if config.TemplateFile != "" {
+		for _, f := range pkg.Syntax {
+			if fileName(config.FileSet, f) == config.TemplateFile {
+				templateFile = f
+			} else {
+				exclude(f)
+			}
+		}
+		if templateFile == nil {
+			return nil, me(nil, "no template file %s in %s", config.TemplateFile, pkg.PkgPath)
+		}
+		if config.TemplateFile != mainFile(config.FileSet, pkg) {
+			for _, f := range composed {
+				exclude(f)
+			}
+		}
+	}
+	if config.TestFiles {
+		for _, f := range pkg.Syntax {
+			if !strings.HasSuffix(fileName(config.FileSet, f), "_test.go") {
+				exclude(f)
+			}
+		}
+		for _, f := range composed {
+			exclude(f)
+		}
+	}
+
 	// collect existing decls
 	existingDecls := make(map[string]func(interface{}))
 	decls := []ast.Decl{}
 	used := NewObjectSet()
 	initFuncs := NewStrSet()
+	owned := NewStrSet()
+	existingSrc := make(map[string]string)
 	var cmap ast.CommentMap
 	mergeComments := func(f *ast.File) {
 		if cmap == nil {
-			cmap = ast.NewCommentMap(loadConf.Fset, f, f.Comments)
+			cmap = ast.NewCommentMap(config.FileSet, f, f.Comments)
 		} else {
-			cm := ast.NewCommentMap(loadConf.Fset, f, f.Comments)
+			cm := ast.NewCommentMap(config.FileSet, f, f.Comments)
 			for key, value := range cm {
 				cmap[key] = value
 			}
@@ -147,9 +502,22 @@ func Copy(config Config) (ret error) {
 						for i, name := range spec.Names {
 							i := i
 							spec := spec
-							existingDecls[name.Name] = func(expr interface{}) {
-								spec.Values[i] = expr.(ast.Expr)
+							existingDecls[name.Name] = func(v interface{}) {
+								value := v.(valueInfo)
+								spec.Type = value.Type
+								switch {
+								case i < len(spec.Values) && value.Value != nil:
+									spec.Values[i] = value.Value
+								case len(spec.Names) == 1 && value.Value != nil:
+									spec.Values = []ast.Expr{value.Value}
+								case len(spec.Names) == 1:
+									spec.Values = nil
+								}
+							}
+							if isGenerated(decl.Doc, spec.Doc) {
+								owned.Add(name.Name)
 							}
+							existingSrc[name.Name] = nodeSource(config.FileSet, spec)
 							used.Add(info.ObjectOf(name))
 						}
 					}
@@ -161,6 +529,10 @@ func Copy(config Config) (ret error) {
 						existingDecls[spec.Name.Name] = func(expr interface{}) {
 							decl.Specs[i].(*ast.TypeSpec).Type = expr.(ast.Expr)
 						}
+						if isGenerated(decl.Doc, spec.Doc) {
+							owned.Add(spec.Name.Name)
+						}
+						existingSrc[spec.Name.Name] = nodeSource(config.FileSet, spec)
 						used.Add(info.ObjectOf(spec.Name))
 					}
 				case token.IMPORT:
@@ -195,6 +567,10 @@ func Copy(config Config) (ret error) {
 					decls[i] = decl
 					used.Add(info.ObjectOf(decl.Name))
 				}
+				if isGenerated(decl.Doc) {
+					owned.Add(name)
+				}
+				existingSrc[name] = nodeSource(config.FileSet, decl)
 				used.Add(info.ObjectOf(decl.Name))
 			}
 		}
@@ -202,12 +578,118 @@ func Copy(config Config) (ret error) {
 	}
 	for _, f := range config.Existing {
 		if err := collectExisting(f); err != nil { //NOCOVER
-			return err
+			return nil, err
+		}
+	}
+
+	// record template positions of output declarations and statements
+	origins := make(map[string]token.Position)
+	stmts := make(map[string][]token.Position)
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			for name, pos := range declPositions(config.FileSet, decl) {
+				origins[name] = pos
+				for _, stmt := range stmtPositions(decl) {
+					stmts[name] = append(stmts[name], config.FileSet.Position(stmt))
+				}
+			}
+		}
+	}
+
+	// read provenances of existing instantiations, and declarations generated by other ones
+	var provenances []Provenance
+	claimedBy := make(map[string]Provenance)
+	if config.Header {
+		provenances, err = ReadProvenance(config.Existing...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range provenances {
+			if p.sameInstantiation(provenance) {
+				continue
+			}
+			for _, name := range p.Decls {
+				claimedBy[name] = p
+			}
+		}
+	}
+
+	// files with neither provenances nor markers are adopted if configured, their declarations replaced as generated ones
+	adopted := config.Adopt && len(provenances) == 0 && len(owned) == 0
+
+	// get declaration dependencies
+	var templateDecls []ast.Decl
+	for _, f := range outputFiles {
+		templateDecls = append(templateDecls, f.Decls...)
+	}
+	deps := declDeps(info, pkg.Types, templateDecls)
+
+	// get objects being used
+	closure := func(used ObjectSet) {
+		for {
+			l := len(used)
+			for use := range used {
+				if deps, ok := deps[use]; ok {
+					for dep := range deps {
+						used.Add(dep)
+					}
+				}
+			}
+			if len(used) == l {
+				break
+			}
+		}
+	}
+	requested, err := parseUses(loader, pkg, config.Uses, renamed) // by uses only, excluding existing declarations
+	if err != nil {
+		return nil, err
+	}
+	closure(requested)
+	for obj := range requested {
+		used.Add(obj)
+	}
+	// generates reports whether the template declaration of id is generated by the uses and the template file filters
+	generates := func(id *ast.Ident) bool {
+		if otherFiles[id] {
+			return false
 		}
+		return len(config.Uses) == 0 || requested.In(info.ObjectOf(id)) || composedIdents[id]
+	}
+
+	// replace returns the mutator of the existing declaration of name.
+	// with Header set, declarations not marked as generated are conflicts,
+	// and different declarations or variables generated by other instantiations are collisions, both left untouched.
+	// existing declarations are left untouched without checks if the template declaration of id is not generated.
+	generated := NewStrSet() // names of declarations emitted or replaced, excluding untouched existing ones
+	var conflicts []string
+	collisions := new(CollisionError)
+	replace := func(name string, id *ast.Ident, node ast.Node) (func(interface{}), bool) {
+		mutator, ok := existingDecls[name]
+		if !ok {
+			generated.Add(name)
+			return nil, false
+		}
+		if _, claimed := claimedBy[name]; config.Header && !generates(id) && (!owned.In(name) || claimed) {
+			// hand-written or generated by other instantiations
+			return func(interface{}) {}, true
+		}
+		if config.Header && !owned.In(name) && !adopted {
+			conflicts = append(conflicts, name)
+			return func(interface{}) {}, true
+		}
+		// identical variables are state shared by instantiations, so collide too
+		_, isVar := info.ObjectOf(id).(*types.Var)
+		if p, ok := claimedBy[name]; ok && (isVar || existingSrc[name] != nodeSource(config.FileSet, node)) {
+			collisions.Names = append(collisions.Names, name)
+			collisions.Others = append(collisions.Others, p)
+			return func(interface{}) {}, true
+		}
+		generated.Add(name)
+		return mutator, true
 	}
 
 	// collect output declarations
-	for _, f := range info.Files {
+	for _, f := range outputFiles {
 		mergeComments(f)
 		for _, decl := range f.Decls {
 			switch decl := decl.(type) {
@@ -220,8 +702,12 @@ func Copy(config Config) (ret error) {
 					for _, spec := range decl.Specs {
 						spec := spec.(*ast.ValueSpec)
 						for i, name := range spec.Names {
-							if mutator, ok := existingDecls[name.Name]; ok {
-								mutator(spec.Values[i])
+							if mutator, ok := replace(name.Name, name, spec); ok {
+								var value ast.Expr
+								if i < len(spec.Values) {
+									value = spec.Values[i]
+								}
+								mutator(valueInfo{name, value, spec.Type})
 							} else {
 								newDecl.Specs = append(newDecl.Specs, spec)
 							}
@@ -237,7 +723,7 @@ func Copy(config Config) (ret error) {
 					for _, spec := range decl.Specs {
 						spec := spec.(*ast.TypeSpec)
 						name := spec.Name.Name
-						if mutator, ok := existingDecls[name]; ok {
+						if mutator, ok := replace(name, spec.Name, spec); ok {
 							mutator(spec.Type)
 						} else {
 							newDecl.Specs = append(newDecl.Specs, spec)
@@ -273,14 +759,14 @@ func Copy(config Config) (ret error) {
 				if name == "init" {
 					src, err := formatNode(decl)
 					if err != nil { //NOCOVER
-						return me(err, "format init func")
+						return nil, me(err, "format init func")
 					}
 					if !initFuncs.In(src) { // not duplicated
 						decls = append(decls, decl)
 					}
 					continue
 				}
-				if mutator, ok := existingDecls[name]; ok {
+				if mutator, ok := replace(name, decl.Name, decl); ok {
 					mutator(decl)
 				} else {
 					decls = append(decls, decl)
@@ -289,85 +775,92 @@ func Copy(config Config) (ret error) {
 		}
 	}
 
-	// get function dependencies
-	deps := make(map[types.Object]ObjectSet)
-	for _, decl := range decls {
-		switch decl := decl.(type) {
-		case *ast.FuncDecl:
-			obj := info.ObjectOf(decl.Name)
-			set := NewObjectSet()
-			var visitor astVisitor
-			visitor = func(node ast.Node) astVisitor {
-				switch node := node.(type) {
-				case *ast.Ident:
-					dep := info.ObjectOf(node)
-					set.Add(dep)
-				}
-				return visitor
+	if len(conflicts) > 0 {
+		return nil, me(&ConflictError{
+			Names: conflicts,
+		}, "check ownership")
+	}
+	if len(collisions.Names) > 0 {
+		return nil, me(collisions, "check collisions")
+	}
+
+	// filter
+	if len(config.Uses) > 0 {
+		closure(used)
+		// filter
+		decls = filterDecls(decls, func(node interface{}) bool {
+			switch node := node.(type) {
+			case *ast.FuncDecl:
+				return used.In(info.ObjectOf(node.Name)) || composedIdents[node.Name]
+			case *ast.TypeSpec:
+				return used.In(info.ObjectOf(node.Name)) || composedIdents[node.Name]
+			case valueInfo:
+				return used.In(info.ObjectOf(node.Name)) || composedIdents[node.Name]
 			}
-			ast.Walk(visitor, decl)
-			deps[obj] = set
-		}
+			return true
+		})
 	}
 
-	// get objects being used
-	for _, use := range config.Uses {
-		parts := strings.Split(use, ".")
-		switch len(parts) {
-		case 2: // method
-			var ty types.Object
-			if from, ok := renamed[parts[0]]; ok { // renamed type, use original type name
-				ty = info.Pkg.Scope().Lookup(from)
-			} else {
-				ty = info.Pkg.Scope().Lookup(parts[0])
-			}
-			typeName, ok := ty.(*types.TypeName)
-			if !ok {
-				return fmt.Errorf("%s is not a type", parts[0])
-			}
-			obj, _, _ := types.LookupFieldOrMethod(typeName.Type(), true, info.Pkg, parts[1])
-			used.Add(obj)
-		case 1: // non-method
-			var obj types.Object
-			if from, ok := renamed[parts[0]]; ok { // renamed function
-				obj = info.Pkg.Scope().Lookup(from)
-			} else {
-				obj = info.Pkg.Scope().Lookup(parts[0])
+	// filter declarations of other template files
+	if len(otherFiles) > 0 {
+		decls = filterDecls(decls, func(node interface{}) bool {
+			switch node := node.(type) {
+			case *ast.FuncDecl:
+				return !otherFiles[node.Name]
+			case *ast.TypeSpec:
+				return !otherFiles[node.Name]
+			case valueInfo:
+				return !otherFiles[node.Name]
 			}
-			used.Add(obj)
-		default:
-			return fmt.Errorf("invalid use spec: %s", use)
-		}
+			return true
+		})
 	}
 
-	// filter
-	if len(config.Uses) > 0 {
-		// calculate uses closure
-		for {
-			l := len(used)
-			for use := range used {
-				if deps, ok := deps[use]; ok {
-					for dep := range deps {
-						used.Add(dep)
+	// remove obsolete generated declarations
+	if config.Header {
+		outputNames := NewStrSet()
+		for _, f := range outputFiles {
+			for _, decl := range f.Decls {
+				for _, id := range declIdents(decl) {
+					name := id.Name
+					if decl, ok := decl.(*ast.FuncDecl); ok {
+						name = getFuncDeclName(decl)
+					}
+					if generated.In(name) && generates(id) {
+						outputNames.Add(name)
 					}
 				}
 			}
-			if len(used) == l {
-				break
+		}
+		obsolete := NewStrSet()
+		claimed := NewStrSet() // by other instantiations
+		for _, p := range provenances {
+			for _, name := range p.Decls {
+				if !p.sameInstantiation(provenance) {
+					claimed.Add(name)
+				} else if !outputNames.In(name) {
+					obsolete.Add(name)
+				}
 			}
 		}
-		// filter
+		keep := func(name string) bool {
+			return !obsolete.In(name) || claimed.In(name) || !owned.In(name)
+		}
 		decls = filterDecls(decls, func(node interface{}) bool {
 			switch node := node.(type) {
 			case *ast.FuncDecl:
-				return used.In(info.ObjectOf(node.Name))
+				return keep(getFuncDeclName(node))
 			case *ast.TypeSpec:
-				return used.In(info.ObjectOf(node.Name))
+				return keep(node.Name.Name)
 			case valueInfo:
-				return used.In(info.ObjectOf(node.Name))
+				return keep(node.Name.Name)
 			}
 			return true
 		})
+		for name := range outputNames {
+			provenance.Decls = append(provenance.Decls, name)
+		}
+		sort.Strings(provenance.Decls)
 	}
 
 	// decls tidy ups
@@ -385,8 +878,9 @@ func Copy(config Config) (ret error) {
 				decl.Doc = new(ast.CommentGroup)
 			}
 		}
-		// move import decls to the beginning
+		// move import decls to the beginning, unpositioned to not precede comments of other files, and grouped by goimports
 		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			unposition(decl)
 			importDecls = append(importDecls, decl)
 			continue
 		}
@@ -395,15 +889,12 @@ func Copy(config Config) (ret error) {
 	decls = append(importDecls, newDecls...)
 
 	// output
-	if config.Writer == nil { //NOCOVER
-		config.Writer = os.Stdout
-	}
 	if config.OutputFile != "" && config.Package == "" { // detect package name
-		buildPkg, err := build.Default.ImportDir(filepath.Dir(config.OutputFile), 0)
+		name, err := packageName(filepath.Dir(config.OutputFile))
 		if err != nil {
-			return me(err, "detect package")
+			return nil, me(err, "detect package")
 		}
-		config.Package = buildPkg.Name
+		config.Package = name
 	}
 	var src interface{}
 	if config.Package != "" {
@@ -417,27 +908,185 @@ func Copy(config Config) (ret error) {
 		src = decls
 	}
 	buf := new(bytes.Buffer)
-	if err := format.Node(buf, program.Fset, src); err != nil { //NOCOVER
-		return me(err, "format")
+	if err := format.Node(buf, config.FileSet, src); err != nil { //NOCOVER
+		return nil, me(err, "format")
 	}
 	var bs []byte
 	if config.Package != "" {
-		bs, err = imports.Process("", buf.Bytes(), nil)
+		bs = buf.Bytes()
+		if len(qualifier.imports) > 0 {
+			bs, err = addImports(bs, qualifier.imports)
+			if err != nil { //NOCOVER
+				return nil, me(err, "add imports")
+			}
+		}
+		bs, err = imports.Process("", bs, nil)
 		if err != nil { //NOCOVER
-			return me(err, "imports")
+			return nil, me(err, "imports")
+		}
+		if config.Simplify {
+			bs, err = simplify(bs, dir, config.OutputFile)
+			if err != nil { //NOCOVER
+				return nil, me(err, "simplify")
+			}
+		}
+		if templateFile != nil {
+			bs = append(fileComments(templateFile), bs...)
 		}
 	} else {
-		bs = buf.Bytes()
+		// parsed as a file of the template package
+		bs = append([]byte("package "+pkg.Name+"\n\n"), buf.Bytes()...)
+	}
+	if config.Header && config.Package != "" {
+		if pkg.Module != nil {
+			provenance.Version = pkg.Module.Version
+		}
+		provenance.Hash, err = hashPackage(pkg)
+		if err != nil {
+			return nil, me(err, "hash template package")
+		}
+		replaced := false
+		for i, p := range provenances {
+			if p.sameInstantiation(provenance) {
+				provenances[i] = provenance
+				replaced = true
+			}
+		}
+		if !replaced {
+			provenances = append(provenances, provenance)
+		}
+		bs, err = markGenerated(bs, generated, pkg.PkgPath)
+		if err != nil { //NOCOVER
+			return nil, me(err, "mark generated declarations")
+		}
+		bs, err = addHeader(bs, provenances)
+		if err != nil { //NOCOVER
+			return nil, me(err, "add header")
+		}
+	}
+
+	result, err := newResult(bs, config.OutputFile, config.Package == "", generated, origins, stmts, config.Existing, pkg.Module)
+	if err != nil {
+		return nil, err
+	}
+	if config.OutputFile != "" && (config.LineDirectives || config.Header && bytes.Contains(bs, []byte("\n//line "))) {
+		// regenerate directives of generated declarations
+		bs, err = result.lineDirectives(bs, config.LineDirectives)
+		if err != nil { //NOCOVER
+			return nil, me(err, "line directives")
+		}
+		result, err = newResult(bs, config.OutputFile, false, generated, origins, stmts, config.Existing, pkg.Module)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Copy instantiates the template package as configured, writing the output to Writer
+func Copy(config Config) error {
+	if config.Writer == nil { //NOCOVER
+		config.Writer = os.Stdout
+	}
+	result, err := Instantiate(config)
+	if err != nil {
+		return err
+	}
+	bs, err := result.Source()
+	if err != nil { //NOCOVER
+		return me(err, "format")
+	}
+	if config.Verify && config.OutputFile != "" {
+		if err := verifyOutput(config.OutputFile, bs, result.origins()); err != nil {
+			return me(err, "verify")
+		}
+	}
+	if config.Check {
+		if config.OutputFile == "" {
+			return me(nil, "no output file to check")
+		}
+		return checkFiles(map[string][]byte{
+			config.OutputFile: bs,
+		})
+	}
+	if config.DryRun {
+		if config.OutputFile == "" {
+			return me(nil, "no output file to diff")
+		}
+		diff, err := unifiedDiff(config.OutputFile, bs)
+		if err != nil {
+			return me(err, "diff")
+		}
+		current, err := os.ReadFile(config.OutputFile)
+		if err != nil && !os.IsNotExist(err) {
+			return me(err, "read output file")
+		}
+		summary, err := summarize(current, bs)
+		if err != nil {
+			return me(err, "summarize")
+		}
+		config.Writer.Write([]byte(diff))
+		config.Writer.Write([]byte(summary.String()))
+		return nil
 	}
 	config.Writer.Write(bs)
+	if config.SourceMap != "" {
+		content, err := json.MarshalIndent(result.SourceMap(), "", "  ")
+		if err != nil { //NOCOVER
+			return me(err, "marshal source map")
+		}
+		if err := os.WriteFile(config.SourceMap, append(content, '\n'), 0644); err != nil {
+			return me(err, "write source map")
+		}
+	}
 
 	return nil
 }
 
-type astVisitor func(ast.Node) astVisitor
+func packageName(dir string) (string, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName,
+		Dir:  dir,
+	}, ".")
+	if err != nil {
+		return "", err
+	}
+	if len(pkgs) != 1 {
+		return "", fmt.Errorf("%s contains %d packages", dir, len(pkgs))
+	}
+	if len(pkgs[0].Errors) > 0 {
+		return "", pkgs[0].Errors[0]
+	}
+	return pkgs[0].Name, nil
+}
+
+// Unwrap returns the underlying error
+func (e *Err) Unwrap() error {
+	return e.Prev
+}
+
+// typeParamsOf returns type parameters of a generic type or function
+func typeParamsOf(obj types.Object) *types.TypeParamList {
+	switch t := obj.Type().(type) {
+	case *types.Named:
+		return t.TypeParams()
+	case *types.Alias:
+		return t.TypeParams()
+	case *types.Signature:
+		return t.TypeParams()
+	}
+	return nil
+}
 
-func (v astVisitor) Visit(node ast.Node) ast.Visitor {
-	return v(node)
+// originOf returns the generic origin of an instantiated function or field
+func originOf(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return obj.Origin()
+	case *types.Var:
+		return obj.Origin()
+	}
+	return obj
 }
 
 type valueInfo struct {
@@ -446,6 +1095,21 @@ type valueInfo struct {
 	Type  ast.Expr
 }
 
+// unposition clears positions of the import declaration and its specs
+func unposition(decl *ast.GenDecl) {
+	decl.TokPos = token.NoPos
+	decl.Lparen = token.NoPos
+	decl.Rparen = token.NoPos
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ImportSpec)
+		if spec.Name != nil {
+			spec.Name.NamePos = token.NoPos
+		}
+		spec.Path.ValuePos = token.NoPos
+		spec.EndPos = token.NoPos
+	}
+}
+
 func filterDecls(decls []ast.Decl, fn func(interface{}) bool) []ast.Decl {
 	decls = AstDecls(decls).Filter(func(decl ast.Decl) (ret bool) {
 		switch decl := decl.(type) {
@@ -499,6 +1163,10 @@ func getFuncDeclName(decl *ast.FuncDecl) (name string) {
 			switch e := expr.(type) {
 			case *ast.StarExpr:
 				expr = e.X
+			case *ast.IndexExpr:
+				expr = e.X
+			case *ast.IndexListExpr:
+				expr = e.X
 			default: //NOCOVER
 				panic(sp("unknown receiver node type %T", expr))
 			}
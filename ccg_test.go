@@ -2,6 +2,8 @@ package ccg
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -14,14 +16,39 @@ import (
 )
 
 func readExpected(path string) []byte {
-	content, err := ioutil.ReadFile(filepath.Join(
-		os.Getenv("GOPATH"), "src", "github.com/reusee/ccg/testdata/", path))
+	content, err := ioutil.ReadFile(filepath.Join("testdata", path))
 	if err != nil {
 		panic(fmt.Sprintf("read file %s: %v", path, err))
 	}
 	return content
 }
 
+func stripHeader(src []byte) []byte {
+	if !bytes.HasPrefix(src, []byte(GeneratedHeader)) {
+		return src
+	}
+	return src[bytes.Index(src, []byte("\npackage "))+1:]
+}
+
+// generate copies the template with config to a buffer, updating existing as the output file if not nil
+func generate(config Config, existing []byte) ([]byte, error) {
+	if config.Package == "" {
+		config.Package = "foo"
+	}
+	if existing != nil {
+		config.FileSet = new(token.FileSet)
+		f, err := parser.ParseFile(config.FileSet, "foo.go", existing, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		config.Existing = []*ast.File{f}
+	}
+	buf := new(bytes.Buffer)
+	config.Writer = buf
+	err := Copy(config)
+	return buf.Bytes(), err
+}
+
 func checkResult(expected, got []byte, t *testing.T) {
 	if !bytes.Equal(expected, got) {
 		pt("== expected ==\n")
@@ -74,7 +101,8 @@ func TestCopy2(t *testing.T) {
 }
 
 func TestOverride(t *testing.T) {
-	f, err := parser.ParseFile(new(token.FileSet), "foo", `
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo", `
 package foo
 import "fmt"
 import ft "fmt"
@@ -91,6 +119,7 @@ const c = 5
 		From:     "github.com/reusee/ccg/testdata/override",
 		Writer:   buf,
 		Existing: []*ast.File{f},
+		FileSet:  fset,
 		Package:  "foo",
 	})
 	if err != nil {
@@ -166,7 +195,8 @@ func TestDeps(t *testing.T) {
 }
 
 func TestDepsWithDecls(t *testing.T) {
-	f, err := parser.ParseFile(new(token.FileSet), "foo", `
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo", `
 package foo
 type B string
 func (b B) Foo() {}
@@ -182,6 +212,7 @@ func (b B) Foo() {}
 		Uses:     []string{"T.Foo"},
 		Package:  "foo",
 		Existing: []*ast.File{f},
+		FileSet:  fset,
 	})
 	if err != nil {
 		t.Fatalf("copy: %v", err)
@@ -205,6 +236,56 @@ func TestDeps2(t *testing.T) {
 	checkResult(expected, buf.Bytes(), t)
 }
 
+func TestDepsGraph(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/graph",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Writer:  buf,
+		Uses:    []string{"Default", "Print"},
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("graph/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+}
+
+func TestDependencies(t *testing.T) {
+	graph, err := Dependencies(Config{
+		From: "github.com/reusee/ccg/testdata/graph",
+		Renames: map[string]string{
+			"Print": "PrintList",
+		},
+		Uses: []string{"Default", "PrintList"},
+	})
+	if err != nil {
+		t.Fatalf("deps: %v", err)
+	}
+	if strings.Join(graph.Deps["Print"], ",") != "List,List.String" {
+		t.Fatalf("got %v", graph.Deps["Print"])
+	}
+	if strings.Join(graph.Closure, ",") != "Default,List,List.String,Node,Print,T,defaultList,newList" {
+		t.Fatalf("got %v", graph.Closure)
+	}
+	if strings.Join(graph.Why["newList"], ",") != "Default,defaultList,newList" {
+		t.Fatalf("got %v", graph.Why["newList"])
+	}
+	checkResult(readExpected("graph/_expected_deps.txt"), []byte(graph.String()), t)
+	checkResult(readExpected("graph/_expected_deps.dot"), []byte(graph.DOT()), t)
+
+	_, err = Dependencies(Config{
+		From: "github.com/reusee/ccg/testdata/graph",
+		Uses: []string{"List.Nope"},
+	})
+	if err == nil || err.Error() != "List has no method Nope" {
+		t.Fatalf("got %v", err)
+	}
+}
+
 func TestImport(t *testing.T) {
 	buf := new(bytes.Buffer)
 	err := Copy(Config{
@@ -261,8 +342,66 @@ func TestInvalidUsesType(t *testing.T) {
 	}
 }
 
+func TestUsesPatterns(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/patterns",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Set": "IntSet",
+		},
+		Writer:  buf,
+		Uses:    []string{"IntSet.*", "!IntSet.Debug", "implements:io.Reader"},
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("patterns/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+
+	// exclusions only
+	buf.Reset()
+	err = Copy(Config{
+		From: "github.com/reusee/ccg/testdata/patterns",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Writer:  buf,
+		Uses:    []string{"!Set.*", "!Reader.Reset"},
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected = readExpected("patterns/_expected2.go")
+	checkResult(expected, buf.Bytes(), t)
+
+	for use, msg := range map[string]string{
+		"Nope":                      "Nope not found",
+		"Set.Nope":                  "Set has no method Nope",
+		"Set.items":                 "Set has no method items",
+		"implements:fmt.Stringer":   "no type implements fmt.Stringer",
+		"implements:sort.Interface": "no type implements sort.Interface",
+		"implements:Set":            "Set is not an interface",
+		"implements:Nope":           "interface Nope not found",
+		"!Nope.*":                   "Nope is not a type",
+	} {
+		err := Copy(Config{
+			From: "github.com/reusee/ccg/testdata/patterns",
+			Uses: []string{use},
+		})
+		if err == nil || err.Error() != msg {
+			t.Fatalf("%s: got %v", use, err)
+		}
+	}
+}
+
 func TestInitFunction(t *testing.T) {
-	f, err := parser.ParseFile(new(token.FileSet), "foo", `
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo", `
 package foo
 func init() {
 	_ = 42
@@ -277,6 +416,7 @@ func init() {
 		Writer:   buf,
 		Package:  "foo",
 		Existing: []*ast.File{f},
+		FileSet:  fset,
 	})
 	if err != nil {
 		t.Fatalf("copy: %v", err)
@@ -286,7 +426,8 @@ func init() {
 }
 
 func TestInitFunction2(t *testing.T) {
-	f, err := parser.ParseFile(new(token.FileSet), "foo", `
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo", `
 package foo
 func init() {
 	_ = "foobar"
@@ -301,6 +442,7 @@ func init() {
 		Writer:   buf,
 		Package:  "foo",
 		Existing: []*ast.File{f},
+		FileSet:  fset,
 	})
 	if err != nil {
 		t.Fatalf("copy: %v", err)
@@ -314,7 +456,7 @@ func TestPkgNameDetect(t *testing.T) {
 	err := Copy(Config{
 		From:       "github.com/reusee/ccg/testdata/pkg",
 		Writer:     buf,
-		OutputFile: filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "reusee", "ccg", "testdata", "pkg", "gen.go"),
+		OutputFile: filepath.Join("testdata", "pkg", "gen.go"),
 	})
 	if err != nil {
 		t.Fatalf("copy: %v", err)
@@ -350,3 +492,1277 @@ func TestComments(t *testing.T) {
 	expected := readExpected("comments/_expected.go")
 	checkResult(expected, buf.Bytes(), t)
 }
+
+func TestRelativeFrom(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "./copy",
+		Dir:  "testdata",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Package: "foo",
+		Writer:  buf,
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("copy/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+}
+
+func TestGeneric(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic",
+		Params: map[string]string{
+			"T1": "int",
+			"T2": "string",
+		},
+		Renames: map[string]string{
+			"Pair": "IntStrPair",
+			"New":  "NewIntStrPair",
+		},
+		Writer:  buf,
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("generic/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+}
+
+func TestGenericAlias(t *testing.T) {
+	// left generic
+	if _, err := generate(Config{
+		From: "github.com/reusee/ccg/testdata/alias",
+	}, nil); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	src, err := generate(Config{
+		From: "github.com/reusee/ccg/testdata/alias",
+		Params: map[string]string{
+			"T": "int",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(readExpected("alias/_expected.go"), src, t)
+
+	// bound as params by directive arguments
+	src, err = generate(Config{
+		From: "github.com/reusee/ccg/testdata/alias",
+		Args: map[string]string{
+			"T": "int",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(readExpected("alias/_expected.go"), src, t)
+}
+
+func TestGenericWithUses(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic",
+		Params: map[string]string{
+			"T1": "int",
+			"T2": "string",
+		},
+		Writer:  buf,
+		Uses:    []string{"Pair.Clone"},
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("generic/_expected2.go")
+	checkResult(expected, buf.Bytes(), t)
+}
+
+func TestGenericPartiallyBound(t *testing.T) {
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic",
+		Params: map[string]string{
+			"T1": "int",
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "partially bound") {
+		t.Fatalf("should fail, got %v", err)
+	}
+}
+
+func TestGenericInconsistentInstantiation(t *testing.T) {
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic2",
+		Params: map[string]string{
+			"T1": "int",
+			"T2": "string",
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "instantiated with different type arguments") {
+		t.Fatalf("should fail, got %v", err)
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/constraint",
+		Params: map[string]string{
+			"T": "Int",
+			"K": "string",
+		},
+		Writer:  buf,
+		Uses:    []string{"Ts.Min"},
+		Package: "foo",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("constraint/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+
+	// other placeholders in method signatures, comparisons to nil, types of the destination package
+	for _, params := range []map[string]string{
+		{"V": "S", "K": "string"},
+		{"V": "Good", "K": "string"},
+		{"E": "[]int"},
+	} {
+		err := Copy(Config{
+			From:       "github.com/reusee/ccg/testdata/constraint",
+			Params:     params,
+			Writer:     new(bytes.Buffer),
+			Package:    "dest",
+			OutputFile: filepath.Join("testdata", "constraint", "dest", "gen.go"),
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", params, err)
+		}
+	}
+}
+
+func TestConstraintViolation(t *testing.T) {
+	cases := []struct {
+		params map[string]string
+		check  func(*ConstraintError) bool
+	}{
+		{
+			map[string]string{"T": "int"},
+			func(e *ConstraintError) bool {
+				return e.Param == "T" && e.Type == "int" && e.Method == "Less"
+			},
+		},
+		{
+			map[string]string{"T": "*Int"},
+			func(e *ConstraintError) bool {
+				return e.Param == "T" && e.Method == "Less" && strings.Contains(e.Reason, "wrong signature")
+			},
+		},
+		{
+			map[string]string{"K": "[]int"},
+			func(e *ConstraintError) bool {
+				return e.Param == "K" && e.Operation == "map key"
+			},
+		},
+		{
+			map[string]string{"V": "time.Time", "K": "string"},
+			func(e *ConstraintError) bool {
+				return e.Param == "V" && e.Method == "Key" && e.Reason == "missing method Key"
+			},
+		},
+		{
+			map[string]string{"V": "Bad", "K": "string"},
+			func(e *ConstraintError) bool {
+				return e.Param == "V" && e.Method == "Key" && strings.Contains(e.Reason, "wrong signature")
+			},
+		},
+		{
+			map[string]string{"V": "Undefined", "K": "string"},
+			func(e *ConstraintError) bool {
+				return e.Param == "V" && strings.HasPrefix(e.Reason, "constraint not checked: ")
+			},
+		},
+		{
+			map[string]string{"V": "S", "K": "Undefined"},
+			func(e *ConstraintError) bool {
+				return e.Param == "K" && strings.HasPrefix(e.Reason, "constraint not checked: ")
+			},
+		},
+	}
+	for _, c := range cases {
+		err := Copy(Config{
+			From:       "github.com/reusee/ccg/testdata/constraint",
+			Params:     c.params,
+			Writer:     new(bytes.Buffer),
+			Package:    "dest",
+			OutputFile: filepath.Join("testdata", "constraint", "dest", "gen.go"),
+		})
+		var e *ConstraintError
+		if !errors.As(err, &e) || !c.check(e) {
+			t.Fatalf("%v: unexpected error %v", c.params, err)
+		}
+	}
+}
+
+func TestTypeParamConstraintViolation(t *testing.T) {
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic3",
+		Params: map[string]string{
+			"T": "float64",
+		},
+	})
+	var e *ConstraintError
+	if !errors.As(err, &e) || e.Param != "T" || e.Type != "float64" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = Copy(Config{
+		From: "github.com/reusee/ccg/testdata/generic3",
+		Params: map[string]string{
+			"E": "int",
+		},
+	})
+	if !errors.As(err, &e) || e.Param != "E" || e.Method != "Less" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Writer:     buf,
+		OutputFile: filepath.Join("testdata", "verify", "gen.go"),
+		Verify:     true,
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+}
+
+func TestVerifyFail(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "undefinedType",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Writer:     buf,
+		OutputFile: filepath.Join("testdata", "verify", "gen.go"),
+		Verify:     true,
+	})
+	var e *VerifyError
+	if !errors.As(err, &e) {
+		t.Fatalf("should fail, got %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Fatal("should not write")
+	}
+	d := e.Diagnostics[0]
+	if filepath.Base(d.Pos.Filename) != "gen.go" ||
+		filepath.Base(d.Template.Filename) != "copy.go" || d.Template.Line != 5 {
+		t.Fatalf("bad diagnostic %v", d)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	for _, name := range []string{"ccg.yaml", "ccg.toml"} {
+		manifest, err := LoadManifest(filepath.Join("testdata", "manifest", name))
+		if err != nil {
+			t.Fatalf("load manifest: %v", err)
+		}
+		outputs, err := manifest.Generate(true)
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		if len(outputs) != 2 {
+			t.Fatalf("expected 2 outputs, got %d", len(outputs))
+		}
+		expected := readExpected("manifest/_expected.go")
+		checkResult(expected, stripHeader(outputs[filepath.Join("testdata", "manifest", "gen.go")]), t)
+		expected = readExpected("manifest/_expected2.go")
+		checkResult(expected, stripHeader(outputs[filepath.Join("testdata", "manifest", "gen2.go")]), t)
+	}
+}
+
+func TestManifestUnknownFormat(t *testing.T) {
+	_, err := LoadManifest(filepath.Join("testdata", "manifest", "manifest.go"))
+	if err == nil || !strings.HasPrefix(err.Error(), "ccg: unknown manifest format") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDirective(t *testing.T) {
+	manifest, err := Discover("testdata", "./directive")
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if len(manifest.Jobs) != 3 {
+		t.Fatalf("expected 3 jobs, got %d", len(manifest.Jobs))
+	}
+	if manifest.Jobs[0].Args["T"] != "int" || manifest.Jobs[1].Args["Pair"] != "IntStrPair" ||
+		manifest.Jobs[2].Args["T"] != "map[string] int" || manifest.Jobs[2].Renames["*"] != "Str*" {
+		t.Fatalf("bad args %v", manifest.Jobs)
+	}
+	outputs, err := manifest.Generate(true)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for path, content := range outputs {
+		if filepath.Base(path) != GeneratedFile {
+			t.Fatalf("bad output path %s", path)
+		}
+		checkResult(readExpected("directive/_expected.go"), stripHeader(content), t)
+	}
+}
+
+func TestInvalidDirective(t *testing.T) {
+	_, err := parseDirective("", InstantiateDirective+" foo T")
+	if err == nil || err.Error() != "ccg: invalid argument T" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = parseDirective("", InstantiateDirective+` foo N="1 << 4`)
+	if err == nil || err.Error() != `ccg: unterminated quote in foo N="1 << 4` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDirectiveArgs(t *testing.T) {
+	job, err := parseDirective("", InstantiateDirective+
+		` foo N="1 << 4" F=`+"`func(a, b int) bool { return a < b }`"+` S="say \"hi\"" T=int *=Int*`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if job.Args["N"] != "1 << 4" ||
+		job.Args["F"] != "func(a, b int) bool { return a < b }" ||
+		job.Args["S"] != `say "hi"` ||
+		job.Args["T"] != "int" ||
+		len(job.Args) != 4 ||
+		len(job.Renames) != 1 || job.Renames["*"] != "Int*" {
+		t.Fatalf("bad job %+v", job)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		OutputFile: filepath.Join("testdata", "check", "check.go"),
+		Check:      true,
+	}
+	if err := Copy(config); err != nil {
+		t.Fatalf("should be up to date: %v", err)
+	}
+	config.Renames = map[string]string{
+		"Ts":  "Ints",
+		"Foo": "MakeInts",
+	}
+	err := Copy(config)
+	var e *StaleError
+	if !errors.As(err, &e) {
+		t.Fatalf("should be stale, got %v", err)
+	}
+	if !strings.Contains(e.Diff, "-func NewInts() (ret Ints) {") ||
+		!strings.Contains(e.Diff, "+func MakeInts() (ret Ints) {") {
+		t.Fatalf("bad diff %s", e.Diff)
+	}
+}
+
+func TestManifestCheck(t *testing.T) {
+	manifest, err := LoadManifest(filepath.Join("testdata", "manifest", "ccg.yaml"))
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	err = manifest.Check(false)
+	var e *StaleError
+	if !errors.As(err, &e) || len(e.Files) != 2 {
+		t.Fatalf("should be stale, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	path := filepath.Join("testdata", "check", "check.go")
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	err = Copy(Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "string",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "MakeInts",
+		},
+		Existing:   []*ast.File{f},
+		FileSet:    fset,
+		Writer:     buf,
+		OutputFile: path,
+		DryRun:     true,
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "-type Ints []int\n+type Ints []string\n") ||
+		!strings.HasSuffix(out, "added: MakeInts\nreplaced: Ints\nunchanged: NewInts, foo\n") {
+		t.Fatalf("bad dry run output\n%s", out)
+	}
+
+	// unparsable file
+	path = filepath.Join(t.TempDir(), "gen.go")
+	if err := os.WriteFile(path, []byte("package foo\n\nfunc {\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf.Reset()
+	err = Copy(Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Package:    "foo",
+		Writer:     buf,
+		OutputFile: path,
+		DryRun:     true,
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, "-func {\n") ||
+		!strings.Contains(out, "+type Ts []int\n") ||
+		!strings.HasSuffix(out, "existing file unparsable\n") {
+		t.Fatalf("bad dry run output\n%s", out)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Package: "foo",
+		Header:  true,
+	}
+	buf := new(bytes.Buffer)
+	config.Writer = buf
+	if err := Copy(config); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(GeneratedHeader+"\n"+ProvenanceDirective+" {")) {
+		t.Fatalf("no header\n%s", buf.Bytes())
+	}
+	checkResult(readExpected("copy/_expected3.go"), stripHeader(buf.Bytes()), t)
+
+	// regenerate with another instantiation
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo.go", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	config.Renames = map[string]string{
+		"Ts":  "Ints",
+		"Foo": "MakeInts",
+	}
+	config.Existing = []*ast.File{f}
+	config.FileSet = fset
+	buf = new(bytes.Buffer)
+	config.Writer = buf
+	if err := Copy(config); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	f, err = parser.ParseFile(fset, "foo.go", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	provenances, err := ReadProvenance(f)
+	if err != nil {
+		t.Fatalf("read provenance: %v", err)
+	}
+	if len(provenances) != 2 ||
+		provenances[0].From != "github.com/reusee/ccg/testdata/copy" ||
+		provenances[0].Renames["Foo"] != "NewInts" ||
+		provenances[1].Renames["Foo"] != "MakeInts" ||
+		!strings.HasPrefix(provenances[1].Hash, "sha256:") {
+		t.Fatalf("bad provenances %+v", provenances)
+	}
+	if bytes.Count(buf.Bytes(), []byte(GeneratedHeader)) != 1 {
+		t.Fatalf("duplicated header\n%s", buf.Bytes())
+	}
+}
+
+func TestRegenerateEmptyMaps(t *testing.T) {
+	// as passed by the command line
+	config := Config{
+		From:    "github.com/reusee/ccg/testdata/copy",
+		Params:  map[string]string{},
+		Renames: map[string]string{},
+		Header:  true,
+	}
+	src, err := generate(config, []byte("package foo\n"))
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	src2, err := generate(config, src)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(src, src2, t)
+}
+
+func TestOwnership(t *testing.T) {
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo", `
+package foo
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type Ints []string
+
+func NewInts() Ints {
+	return nil
+}
+
+var (
+	//ccg:generated github.com/reusee/ccg/testdata/copy
+	foo = 1
+)
+	`, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Existing: []*ast.File{f},
+		FileSet:  fset,
+		Package:  "foo",
+		Header:   true,
+		Writer:   new(bytes.Buffer),
+	}
+	err = Copy(config)
+	var e *ConflictError
+	if !errors.As(err, &e) || len(e.Names) != 1 || e.Names[0] != "NewInts" {
+		t.Fatalf("should conflict, got %v", err)
+	}
+
+	// not conflicting without Header
+	config.Header = false
+	if err := Copy(config); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+}
+
+func TestOwnershipWithUses(t *testing.T) {
+	config := Config{
+		From:   "github.com/reusee/ccg/testdata/deps",
+		Header: true,
+	}
+	existing := []byte("package foo\n\n//ccg:generated github.com/reusee/ccg/testdata/deps\nfunc (t T) Qux() {}\n\ntype B string\n")
+	_, err := generate(config, existing)
+	var e *ConflictError
+	if !errors.As(err, &e) || len(e.Names) != 1 || e.Names[0] != "B" {
+		t.Fatalf("should conflict, got %v", err)
+	}
+
+	// B not generated
+	config.Uses = []string{"T.Foo"}
+	src, err := generate(config, existing)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !bytes.Contains(src, []byte("type B string")) ||
+		!bytes.Contains(src, []byte("func (t T) Foo() {")) ||
+		bytes.Contains(src, []byte(GeneratedMarker+" github.com/reusee/ccg/testdata/deps\ntype B")) {
+		t.Fatalf("bad output\n%s", src)
+	}
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo.go", existing, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	config.Package = "foo"
+	config.Existing = []*ast.File{f}
+	config.FileSet = fset
+	result, err := Instantiate(config)
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+	for _, decl := range result.Decls {
+		if decl.Name == "B" {
+			t.Fatalf("B listed as generated")
+		}
+	}
+
+	// still hand-written
+	config.Uses = nil
+	config.Existing = nil
+	_, err = generate(config, src)
+	if !errors.As(err, &e) || len(e.Names) != 1 || e.Names[0] != "B" {
+		t.Fatalf("should conflict, got %v", err)
+	}
+}
+
+func TestOwnershipLegacy(t *testing.T) {
+	// generated before ownership markers, or hand-written
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		Header: true,
+	}
+	existing := []byte("package foo\n\ntype Ints []string\n\n// NewInts is hand-written\nfunc NewInts() Ints {\n\treturn nil\n}\n\nfunc Other() {}\n")
+	_, err := generate(config, existing)
+	var e *ConflictError
+	if !errors.As(err, &e) || strings.Join(e.Names, ",") != "Ints,NewInts" {
+		t.Fatalf("should conflict, got %v", err)
+	}
+
+	config.Adopt = true
+	src, err := generate(config, existing)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		ProvenanceDirective,
+		GeneratedMarker + " github.com/reusee/ccg/testdata/copy\ntype Ints []int",
+		"func Other() {}",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+}
+
+func TestRemoveObsolete(t *testing.T) {
+	config := Config{
+		From:    "github.com/reusee/ccg/testdata/deps",
+		Params:  map[string]string{}, // as passed by the command line
+		Renames: map[string]string{},
+		Uses:    []string{"T.Bar"},
+		Header:  true,
+	}
+	src, err := generate(config, []byte("package foo\n\nfunc Bar() {}\n"))
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !bytes.Contains(src, []byte("func (t T) Bar() {")) {
+		t.Fatalf("T.Bar not generated\n%s", src)
+	}
+	config.Uses = []string{"T.Foo"}
+	src, err = generate(config, src)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if bytes.Contains(src, []byte("func (t T) Bar() {")) {
+		t.Fatalf("T.Bar not removed\n%s", src)
+	}
+	if !bytes.Contains(src, []byte("func (t T) Foo() {")) ||
+		!bytes.Contains(src, []byte("func Bar() {}")) {
+		t.Fatalf("bad output\n%s", src)
+	}
+	if bytes.Count(src, []byte(ProvenanceDirective)) != 1 {
+		t.Fatalf("provenance not replaced\n%s", src)
+	}
+}
+
+func TestCollision(t *testing.T) {
+	instantiate := func(src []byte, elem, set, suffix string) ([]byte, error) {
+		return generate(Config{
+			From: "github.com/reusee/ccg/testdata/collide",
+			Params: map[string]string{
+				"T": elem,
+			},
+			Renames: map[string]string{
+				"Set": set,
+			},
+			HelperSuffix: suffix,
+			Header:       true,
+		}, src)
+	}
+
+	src, err := instantiate([]byte("package foo\n"), "int", "IntSet", "")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	_, err = instantiate(src, "string", "StrSet", "")
+	var e *CollisionError
+	if !errors.As(err, &e) || strings.Join(e.Names, ",") != "count,less" ||
+		e.Others[0].Renames["Set"] != "IntSet" {
+		t.Fatalf("should collide, got %v", err)
+	}
+
+	src, err = instantiate([]byte("package foo\n"), "int", "IntSet", "IntSet")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	src, err = instantiate(src, "string", "StrSet", "StrSet")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		"func lessIntSet(a, b int) bool {",
+		"func lessStrSet(a, b string) bool {",
+		"var countIntSet int",
+		"countStrSet++",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+}
+
+func TestRenamePattern(t *testing.T) {
+	instantiate := func(renames map[string]string, unexported bool) ([]byte, error) {
+		return generate(Config{
+			From: "github.com/reusee/ccg/testdata/copy",
+			Params: map[string]string{
+				"T": "int",
+			},
+			Renames:          renames,
+			RenameUnexported: unexported,
+		}, nil)
+	}
+
+	src, err := instantiate(map[string]string{
+		"*":   "Int*",
+		"Foo": "NewInts",
+	}, false)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		"type IntTs []int",
+		"func NewInts() (ret IntTs) {",
+		"const foo = 42",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	src, err = instantiate(map[string]string{
+		"*":  "*Set",
+		"F*": "New*",
+	}, true)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		"type TsSet []int",
+		"func Newoo() (ret TsSet) {",
+		"const fooSet = 42",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	_, err = instantiate(map[string]string{
+		"*": "Int",
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "invalid rename pattern") {
+		t.Fatalf("should fail, got %v", err)
+	}
+}
+
+func TestQualifiedParam(t *testing.T) {
+	instantiate := func(src string, elem string) ([]byte, error) {
+		return generate(Config{
+			From: "github.com/reusee/ccg/testdata/copy",
+			Params: map[string]string{
+				"T": elem,
+			},
+		}, []byte(src))
+	}
+
+	src, err := instantiate("package foo\n", "map[string][]github.com/reusee/ccg/testdata/model.*User")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		`import "github.com/reusee/ccg/testdata/model"`,
+		"type Ts []map[string][]*model.User",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	src, err = instantiate(`package foo
+import model "github.com/reusee/ccg/testdata/pkg"
+var _ = model.Foo
+`, "github.com/reusee/ccg/testdata/model.User")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		`model2 "github.com/reusee/ccg/testdata/model"`,
+		"type Ts []model2.User",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	// standard library packages
+	src, err = instantiate("package foo\n\nvar time = 1\n", "map[time.Duration]*bytes.Buffer")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		`"bytes"`,
+		`time2 "time"`,
+		"type Ts []map[time2.Duration]*bytes.Buffer",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	_, err = instantiate("package foo\n", "github.com/reusee/ccg/testdata/non-exists.User")
+	if err == nil || !strings.HasPrefix(err.Error(), "ccg: qualify param T") {
+		t.Fatalf("should fail, got %v", err)
+	}
+}
+
+func TestValueParam(t *testing.T) {
+	instantiate := func(params map[string]string) ([]byte, error) {
+		return generate(Config{
+			From:   "github.com/reusee/ccg/testdata/value",
+			Params: params,
+		}, nil)
+	}
+	params := map[string]string{
+		"Size":  "1<<20",
+		"Max":   "42",
+		"Name":  "say \"hi\" `there`",
+		"Ratio": "0.5",
+		"Debug": "true",
+		"Mode":  "3",
+	}
+
+	src, err := instantiate(params)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("value/_expected.go")
+	checkResult(expected, src, t)
+
+	// bound as params by directive arguments
+	src, err = generate(Config{
+		From: "github.com/reusee/ccg/testdata/value",
+		Args: params,
+	}, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(expected, src, t)
+
+	_, err = generate(Config{
+		From: "github.com/reusee/ccg/testdata/value",
+		Renames: map[string]string{
+			"Limit": "1<<20",
+		},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "rename Limit to 1<<20: not an identifier") {
+		t.Fatalf("should fail, got %v", err)
+	}
+
+	for _, c := range []struct {
+		name, value string
+	}{
+		{"Size", "1 +"},
+		{"Size", `"x"`},
+		{"Size", "true"},
+		{"Max", "1<<70"},
+		{"Debug", "42"},
+		{"Ratio", `"foo"`},
+	} {
+		invalid := make(map[string]string)
+		for k, v := range params {
+			invalid[k] = v
+		}
+		invalid[c.name] = c.value
+		_, err := instantiate(invalid)
+		if err == nil || !strings.Contains(err.Error(), "param "+c.name) {
+			t.Fatalf("%s=%s should fail, got %v", c.name, c.value, err)
+		}
+	}
+}
+
+func TestFuncParam(t *testing.T) {
+	instantiate := func(params map[string]string) ([]byte, error) {
+		return generate(Config{
+			From:   "github.com/reusee/ccg/testdata/funcparam",
+			Params: params,
+		}, nil)
+	}
+
+	src, err := instantiate(map[string]string{
+		"T":       "string",
+		"compare": "strings.Compare",
+		"equal":   "strings.EqualFold",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("funcparam/_expected.go")
+	checkResult(expected, src, t)
+
+	src, err = instantiate(map[string]string{
+		"T":       "github.com/reusee/ccg/testdata/model.User",
+		"compare": "github.com/reusee/ccg/testdata/model.Compare",
+		"equal":   "reflect.DeepEqual",
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, s := range []string{
+		`"github.com/reusee/ccg/testdata/model"`,
+		"if model.Compare(a, b) < 0 {",
+		"if reflect.DeepEqual(e, v) {",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Fatalf("%s not generated\n%s", s, src)
+		}
+	}
+
+	_, err = instantiate(map[string]string{
+		"T":       "string",
+		"compare": "strings.Contains",
+	})
+	if err == nil || !strings.Contains(err.Error(), "wrong signature of strings.Contains") {
+		t.Fatalf("should fail, got %v", err)
+	}
+	_, err = instantiate(map[string]string{
+		"T":       "string",
+		"compare": "func(a, b string) int { return 0 }",
+	})
+	if err == nil || !strings.Contains(err.Error(), "is not a function name") {
+		t.Fatalf("should fail, got %v", err)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	for elem, expected := range map[string]string{
+		"int":    "simplify/_expected.go",
+		"string": "simplify/_expected2.go",
+	} {
+		src, err := generate(Config{
+			From: "github.com/reusee/ccg/testdata/simplify",
+			Params: map[string]string{
+				"T": elem,
+			},
+			Simplify: true,
+		}, nil)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		checkResult(readExpected(expected), src, t)
+	}
+}
+
+func TestSimplifyInPackage(t *testing.T) {
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/simplify",
+		Params: map[string]string{
+			"T": "MyType",
+		},
+		Simplify: true,
+		Package:  "out",
+	}
+	// MyType declared in the destination package
+	config.OutputFile = filepath.Join("testdata", "simplify", "out", "gen.go")
+	src, err := generate(config, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(readExpected("simplify/_expected3.go"), src, t)
+
+	// unresolved without the destination package, not pruned
+	config.OutputFile = ""
+	src, err = generate(config, nil)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	checkResult(readExpected("simplify/_expected4.go"), src, t)
+}
+
+func TestCompose(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Copy(Config{
+		From: "github.com/reusee/ccg/testdata/compose/sortedmap",
+		Params: map[string]string{
+			"K": "string",
+			"V": "int",
+		},
+		Renames: map[string]string{
+			"Map": "StrIntMap",
+		},
+		Package: "foo",
+		Writer:  buf,
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	expected := readExpected("compose/_expected.go")
+	checkResult(expected, buf.Bytes(), t)
+}
+
+func TestInstantiate(t *testing.T) {
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "foo.go", `package foo
+import "fmt"
+var _ = fmt.Printf
+`, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	result, err := Instantiate(Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "github.com/reusee/ccg/testdata/model.User",
+		},
+		Renames: map[string]string{
+			"Ts":  "Users",
+			"Foo": "NewUsers",
+		},
+		Existing: []*ast.File{f},
+		FileSet:  fset,
+		Package:  "foo",
+	})
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+	if result.File.Name.Name != "foo" {
+		t.Fatalf("wrong package %s", result.File.Name.Name)
+	}
+	var names []string
+	for _, decl := range result.Decls {
+		names = append(names, decl.Name)
+		if filepath.Base(decl.Template.Filename) != "copy.go" {
+			t.Fatalf("wrong template position of %s: %v", decl.Name, decl.Template)
+		}
+	}
+	if strings.Join(names, ",") != "Users,NewUsers,foo" {
+		t.Fatalf("wrong decls %v", names)
+	}
+	if len(result.Decls) != 3 || result.Decls[1].Template.Line != 7 {
+		t.Fatalf("wrong template position %v", result.Decls[1].Template)
+	}
+	if len(result.Imports) != 1 || result.Imports[0] != "github.com/reusee/ccg/testdata/model" {
+		t.Fatalf("wrong imports %v", result.Imports)
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	output := filepath.Join("testdata", "verify", "gen.go")
+	sourceMap := filepath.Join(t.TempDir(), "gen.json")
+	config := Config{
+		From: "github.com/reusee/ccg/testdata/copy",
+		Params: map[string]string{
+			"T": "int",
+		},
+		Renames: map[string]string{
+			"Ts":  "Ints",
+			"Foo": "NewInts",
+		},
+		OutputFile:     output,
+		Verify:         true,
+		Header:         true,
+		LineDirectives: true,
+		SourceMap:      sourceMap,
+	}
+	buf := new(bytes.Buffer)
+	config.Writer = buf
+	if err := Copy(config); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, directive := range []string{
+		"\n//line ../copy/copy.go:7\nfunc NewInts",
+		"\n//line ../copy/copy.go:8\n\treturn",
+		"}\n\n//line gen.go:20\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(directive)) {
+			t.Fatalf("no directive %q\n%s", directive, buf.Bytes())
+		}
+	}
+	content, err := ioutil.ReadFile(sourceMap)
+	if err != nil {
+		t.Fatalf("read source map: %v", err)
+	}
+	var m SourceMap
+	if err := json.Unmarshal(content, &m); err != nil {
+		t.Fatalf("unmarshal source map: %v", err)
+	}
+	if len(m.Mappings) != 4 ||
+		m.Mappings[2] != (LineMapping{Line: 16, TemplateFile: "../copy/copy.go", TemplateLine: 8}) {
+		t.Fatalf("bad source map %+v", m)
+	}
+
+	// regenerate
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, output, buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	config.Existing = []*ast.File{f}
+	config.FileSet = fset
+	buf2 := new(bytes.Buffer)
+	config.Writer = buf2
+	if err := Copy(config); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), buf2.Bytes()) {
+		t.Fatalf("not stable\n%s", buf2.Bytes())
+	}
+}
+
+func TestLineDirectivesModule(t *testing.T) {
+	sourceMap := filepath.Join(t.TempDir(), "gen.json")
+	buf := new(bytes.Buffer)
+	if err := Copy(Config{
+		From:           "github.com/pmezard/go-difflib/difflib",
+		Package:        "foo",
+		Uses:           []string{"SplitLines"},
+		OutputFile:     filepath.Join("testdata", "verify", "gen.go"),
+		LineDirectives: true,
+		SourceMap:      sourceMap,
+		Writer:         buf,
+	}); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	// not relative to the module cache
+	file := "github.com/pmezard/go-difflib@v1.0.0/difflib/difflib.go"
+	if !bytes.Contains(buf.Bytes(), []byte("\n//line "+file+":")) {
+		t.Fatalf("no module-relative directive\n%s", buf.Bytes())
+	}
+	content, err := ioutil.ReadFile(sourceMap)
+	if err != nil {
+		t.Fatalf("read source map: %v", err)
+	}
+	var m SourceMap
+	if err := json.Unmarshal(content, &m); err != nil {
+		t.Fatalf("unmarshal source map: %v", err)
+	}
+	if len(m.Mappings) == 0 || m.Mappings[0].TemplateFile != file {
+		t.Fatalf("bad source map %+v", m)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	manifest := &Manifest{
+		Dir: "testdata",
+		Jobs: []Job{
+			{
+				From: "./split",
+				Params: map[string]string{
+					"T": "int",
+				},
+				Renames: map[string]string{
+					"Set": "IntSet",
+					"New": "NewIntSet",
+				},
+				Output: filepath.Join("split", "out", "set_intset.go"),
+				Split:  true,
+			},
+		},
+	}
+	outputs, err := manifest.Generate(true)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(outputs) != 2 { // nothing generated from params.go
+		t.Fatalf("expected 2 outputs, got %d", len(outputs))
+	}
+	dir := filepath.Join("testdata", "split", "out")
+	checkResult(readExpected("split/_expected.go"), stripHeader(outputs[filepath.Join(dir, "set_intset.go")]), t)
+	checkResult(readExpected("split/_expected_iter.go"), stripHeader(outputs[filepath.Join(dir, "set_intset_iter.go")]), t)
+	if !bytes.Contains(outputs[filepath.Join(dir, "set_intset.go")], []byte("\n// Copyright 2026 The ccg Authors.\n\npackage out\n")) ||
+		!bytes.Contains(outputs[filepath.Join(dir, "set_intset_iter.go")], []byte("\n//go:build go1.23\n\npackage out\n")) {
+		t.Fatal("file comments not preserved")
+	}
+
+	// regenerate
+	for path, content := range outputs {
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		path := path
+		t.Cleanup(func() {
+			os.Remove(path)
+		})
+	}
+	if err := manifest.Check(true); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+}
+
+func TestTestFiles(t *testing.T) {
+	manifest := &Manifest{
+		Dir: "testdata",
+		Jobs: []Job{
+			{
+				From: "./tested",
+				Params: map[string]string{
+					"T": "float64",
+				},
+				Renames: map[string]string{
+					"*": "*Float64",
+				},
+				Output: filepath.Join("tested", "out", "float64s.go"),
+				Tests:  true,
+			},
+		},
+	}
+	outputs, err := manifest.Generate(true)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	dir := filepath.Join("testdata", "tested", "out")
+	checkResult(readExpected("tested/_expected.go"), stripHeader(outputs[filepath.Join(dir, "float64s.go")]), t)
+	checkResult(readExpected("tested/_expected_test.go"), stripHeader(outputs[filepath.Join(dir, "float64s_test.go")]), t)
+}
@@ -0,0 +1,69 @@
+package ccg
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// StaleError reports generated files not matching what would be generated now
+type StaleError struct {
+	Files []string
+	Diff  string // unified diff from the current contents to the generated ones
+}
+
+func (e *StaleError) Error() string {
+	return sp("stale generated files: %s", strings.Join(e.Files, ", "))
+}
+
+// unifiedDiff returns the unified diff from the current content of path to content, or empty string if identical
+func unifiedDiff(path string, content []byte) (string, error) {
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if bytes.Equal(current, content) {
+		return "", nil
+	}
+	lines := func(content []byte) []string {
+		if len(content) == 0 {
+			return nil
+		}
+		return difflib.SplitLines(string(content))
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(current),
+		B:        lines(content),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+}
+
+// checkFiles compares generated contents with files on disk
+func checkFiles(outputs map[string][]byte) error {
+	var paths []string
+	for path := range outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	stale := new(StaleError)
+	for _, path := range paths {
+		diff, err := unifiedDiff(path, outputs[path])
+		if err != nil {
+			return me(err, "diff %s", path)
+		}
+		if diff == "" {
+			continue
+		}
+		stale.Files = append(stale.Files, path)
+		stale.Diff += diff
+	}
+	if len(stale.Files) > 0 {
+		return stale
+	}
+	return nil
+}
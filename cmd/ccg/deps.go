@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/reusee/ccg"
+)
+
+type depsCommand struct {
+	Renames string `short:"r" description:"renames, for uses referring to renamed names"`
+	Uses    string `short:"u" description:"names or patterns to print the closure of, with paths to each declaration"`
+	DOT     bool   `long:"dot" description:"print in the Graphviz DOT language"`
+	JSON    bool   `long:"json" description:"print in JSON"`
+}
+
+func (c *depsCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ccg deps [options] <template>")
+	}
+	if c.DOT && c.JSON {
+		return errors.New("--dot and --json conflict")
+	}
+	renames := map[string]string{}
+	if len(c.Renames) > 0 {
+		for _, pairStr := range strings.Split(c.Renames, ",") {
+			pair := strings.SplitN(pairStr, "=", 2)
+			if len(pair) != 2 {
+				return errors.New("invalid rename spec: " + pairStr)
+			}
+			renames[pair[0]] = pair[1]
+		}
+	}
+	var uses []string
+	if len(c.Uses) > 0 {
+		uses = strings.Split(c.Uses, ",")
+	}
+	graph, err := ccg.Dependencies(ccg.Config{
+		From:    args[0],
+		Renames: renames,
+		Uses:    uses,
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.DOT:
+		pt("%s", graph.DOT())
+	case c.JSON:
+		bs, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			return err
+		}
+		pt("%s\n", bs)
+	default:
+		pt("%s", graph)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/reusee/ccg"
+)
+
+type genCommand struct {
+	NoVerify bool `long:"no-verify" description:"do not type-check output files before writing"`
+	Check    bool `long:"check" description:"exit non-zero and print a diff if any output file is stale, instead of writing"`
+}
+
+func (c *genCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	manifest, err := ccg.Discover("", args...)
+	if err != nil {
+		return err
+	}
+	if c.Check {
+		return reportStale(manifest.Check(!c.NoVerify))
+	}
+	return manifest.Run(!c.NoVerify)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,20 +21,70 @@ var (
 )
 
 var opts struct {
-	From    string `short:"f" description:"template package import path"`
+	From    string `short:"f" description:"template package import path or relative directory"`
 	Params  string `short:"t" description:"parameters"`
 	Renames string `short:"r" description:"renames"`
 	Package string `short:"p" description:"output package name"`
 	Output  string `short:"o" description:"output file path"`
-	Uses    string `short:"u" description:"names to be used only"`
+	Uses    string `short:"u" description:"names to be used only, or patterns as Type.*, implements:io.Reader and !Name"`
+	Suffix  string `long:"helper-suffix" description:"suffix appended to unexported names not renamed explicitly"`
+
+	RenamePrefix     string `long:"prefix" description:"prefix prepended to exported names not renamed explicitly, same as -r '*=<prefix>*'"`
+	RenameSuffix     string `long:"suffix" description:"suffix appended to exported names not renamed explicitly, same as -r '*=*<suffix>'"`
+	RenameUnexported bool   `long:"rename-unexported" description:"apply rename patterns to unexported names too"`
+	Simplify         bool   `long:"simplify" description:"prune dead branches of constant conditions and type switches after substitution"`
+	LineDirectives   bool   `long:"line-directives" description:"emit //line directives pointing generated code to the template, requires -o"`
+	SourceMap        string `long:"source-map" description:"write a JSON map from generated lines to template positions to the file"`
+	Split            bool   `long:"split" description:"generate one file per template file, named after the output file, requires -o"`
+	Tests            bool   `long:"tests" description:"also generate the _test.go file of the output file from test files of the template, requires -o"`
+
+	NoVerify bool `long:"no-verify" description:"do not type-check the output file before writing"`
+	Check    bool `long:"check" description:"exit non-zero and print a diff if the output file is stale, instead of writing"`
+	DryRun   bool `long:"dry-run" description:"print a diff and a summary of declaration changes, instead of writing"`
+	Adopt    bool `long:"adopt" description:"replace declarations of an output file without provenances or markers, such as one written by an older ccg"`
 }
 
 func main() {
-	_, err := flags.Parse(&opts)
+	flagParser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	flagParser.SubcommandsOptional = true
+	flagParser.AddCommand("run", "run a generation manifest",
+		"Execute all jobs listed in a manifest file, default to ccg.yaml or ccg.toml in the current directory",
+		new(runCommand))
+	flagParser.AddCommand("gen", "run instantiate directives",
+		"Execute all "+ccg.InstantiateDirective+" directives in packages, writing to "+ccg.GeneratedFile+" in each package",
+		new(genCommand))
+	flagParser.AddCommand("deps", "print the dependency graph of a template",
+		"Print dependencies of declarations of a template package, and with -u, the declarations generated for the uses and why each is generated",
+		new(depsCommand))
+	_, err := flagParser.Parse()
+	var flagsErr *flags.Error
+	if errors.As(err, &flagsErr) && flagsErr.Type == flags.ErrHelp {
+		pt("%s\n", flagsErr.Message)
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	if flagParser.Active != nil { // subcommand executed
+		return
+	}
 
+	if len(opts.From) == 0 && opts.Output != "" {
+		// regenerate from provenances recorded in the output file
+		manifest, err := ccg.ProvenanceManifest(opts.Output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.Check {
+			err = reportStale(manifest.Check(!opts.NoVerify))
+		} else {
+			err = manifest.Run(!opts.NoVerify)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if len(opts.From) == 0 {
 		log.Fatal("no template package specified")
 	}
@@ -61,6 +112,12 @@ func main() {
 			renames[pair[0]] = pair[1]
 		}
 	}
+	if opts.RenamePrefix != "" || opts.RenameSuffix != "" {
+		if _, ok := renames[ccg.RenameWildcard]; ok {
+			log.Fatalf("--prefix and --suffix conflict with rename pattern %s", ccg.RenameWildcard)
+		}
+		renames[ccg.RenameWildcard] = opts.RenamePrefix + ccg.RenameWildcard + opts.RenameSuffix
+	}
 
 	existing := []*ast.File{}
 	fileSet := new(token.FileSet)
@@ -81,22 +138,72 @@ func main() {
 		}
 	}
 
+	if opts.Split || opts.Tests {
+		if opts.Output == "" {
+			log.Fatal("--split and --tests require an output file")
+		}
+		if opts.DryRun || opts.SourceMap != "" {
+			log.Fatal("--dry-run and --source-map are not supported with --split and --tests")
+		}
+		manifest := &ccg.Manifest{
+			Jobs: []ccg.Job{{
+				From:             opts.From,
+				Params:           params,
+				Renames:          renames,
+				Uses:             usesNames,
+				Suffix:           opts.Suffix,
+				Package:          opts.Package,
+				Output:           opts.Output,
+				RenameUnexported: opts.RenameUnexported,
+				Simplify:         opts.Simplify,
+				LineDirectives:   opts.LineDirectives,
+				Split:            opts.Split,
+				Tests:            opts.Tests,
+				Adopt:            opts.Adopt,
+			}},
+		}
+		if opts.Check {
+			err = reportStale(manifest.Check(!opts.NoVerify))
+		} else {
+			err = manifest.Run(!opts.NoVerify)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	buf := new(bytes.Buffer)
 	err = ccg.Copy(ccg.Config{
-		From:       opts.From,
-		Params:     params,
-		Renames:    renames,
-		Writer:     buf,
-		Package:    opts.Package,
-		Existing:   existing,
-		FileSet:    fileSet,
-		Uses:       usesNames,
-		OutputFile: opts.Output,
+		From:             opts.From,
+		Params:           params,
+		Renames:          renames,
+		Writer:           buf,
+		Package:          opts.Package,
+		Existing:         existing,
+		FileSet:          fileSet,
+		Uses:             usesNames,
+		HelperSuffix:     opts.Suffix,
+		RenameUnexported: opts.RenameUnexported,
+		Simplify:         opts.Simplify,
+		LineDirectives:   opts.LineDirectives,
+		OutputFile:       opts.Output,
+		Verify:           !opts.NoVerify,
+		Check:            opts.Check,
+		DryRun:           opts.DryRun,
+		Header:           opts.Output != "",
+		Adopt:            opts.Adopt,
+		SourceMap:        opts.SourceMap,
 	})
 	if err != nil {
-		log.Fatalf("ccg: copy error %v", err)
+		log.Fatalf("ccg: copy error %v", reportStale(err))
+	}
+	if opts.Check {
+		return
 	}
-	if opts.Output == "" {
+	if opts.DryRun {
+		pt("%s", buf.Bytes())
+	} else if opts.Output == "" {
 		pt("%s\n", buf.Bytes())
 	} else {
 		err = ioutil.WriteFile(opts.Output, buf.Bytes(), 0644)
@@ -105,3 +212,12 @@ func main() {
 		}
 	}
 }
+
+// reportStale prints the diff of stale files
+func reportStale(err error) error {
+	var stale *ccg.StaleError
+	if errors.As(err, &stale) {
+		pt("%s", stale.Diff)
+	}
+	return err
+}
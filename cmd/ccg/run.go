@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/reusee/ccg"
+)
+
+type runCommand struct {
+	NoVerify bool `long:"no-verify" description:"do not type-check output files before writing"`
+	Check    bool `long:"check" description:"exit non-zero and print a diff if any output file is stale, instead of writing"`
+}
+
+func (c *runCommand) Execute(args []string) error {
+	var path string
+	switch len(args) {
+	case 0:
+		for _, name := range []string{"ccg.yaml", "ccg.yml", "ccg.toml"} {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return errors.New("no manifest file found")
+		}
+	case 1:
+		path = args[0]
+	default:
+		return errors.New("usage: ccg run [manifest]")
+	}
+	manifest, err := ccg.LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	if c.Check {
+		return reportStale(manifest.Check(!c.NoVerify))
+	}
+	return manifest.Run(!c.NoVerify)
+}
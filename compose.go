@@ -0,0 +1,353 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// TemplateDirective marks an import of another template package, instantiated into the same output, in the form of
+//
+//	import "example.com/set" //ccg:template Name=Value...
+//
+// Values naming params of the importing template are replaced by their bindings.
+// Params of the same names are bound as in the importing template, and type parameters as instantiated by it.
+const TemplateDirective = "//ccg:template"
+
+// composition is an instantiation of a template package imported by another template
+type composition struct {
+	pkg         *packages.Package
+	names       map[string]string // template names to output names
+	specialized StrSet            // generic declarations with all type parameters bound
+	file        *ast.File         // output declarations
+}
+
+// templateImports returns arguments of imports marked with the template directive, by import path
+func templateImports(pkg *packages.Package) (map[string]map[string]string, error) {
+	ret := make(map[string]map[string]string)
+	for _, f := range pkg.Syntax {
+		for _, spec := range f.Imports {
+			var directive string
+			for _, group := range []*ast.CommentGroup{spec.Doc, spec.Comment} {
+				if group == nil {
+					continue
+				}
+				for _, comment := range group.List {
+					if comment.Text == TemplateDirective || strings.HasPrefix(comment.Text, TemplateDirective+" ") {
+						directive = comment.Text
+					}
+				}
+			}
+			if directive == "" {
+				continue
+			}
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil { //NOCOVER
+				return nil, err
+			}
+			args, ok := ret[path]
+			if !ok {
+				args = make(map[string]string)
+				ret[path] = args
+			}
+			for _, field := range strings.Fields(strings.TrimPrefix(directive, TemplateDirective)) {
+				pair := strings.SplitN(field, "=", 2)
+				if len(pair) != 2 {
+					return nil, me(nil, "invalid argument %s", field)
+				}
+				if prev, ok := args[pair[0]]; ok && prev != pair[1] {
+					return nil, me(nil, "%s imported with different arguments", path)
+				}
+				args[pair[0]] = pair[1]
+			}
+		}
+	}
+	return ret, nil
+}
+
+// compose instantiates templates imported by pkg with template directives.
+// objects maps objects of pkg to their substitutions.
+func compose(config Config, loader *Loader, pkg *packages.Package, objects map[types.Object]string) (map[string]*composition, error) {
+	imports, err := templateImports(pkg)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	info := pkg.TypesInfo
+	typeArg := func(t types.Type) string {
+		switch t := t.(type) {
+		case *types.TypeParam:
+			return objects[t.Obj()]
+		case *types.Named:
+			if to, ok := objects[t.Obj()]; ok {
+				return to
+			}
+		}
+		return types.TypeString(t, types.RelativeTo(pkg.Types))
+	}
+
+	ret := make(map[string]*composition)
+	for _, path := range paths {
+		imported, err := loader.Load(path)
+		if err != nil {
+			return nil, me(err, "load template %s", path)
+		}
+		placeholders := paramNames(imported)
+		params := make(map[string]string)
+		renames := make(map[string]string)
+		for name, value := range imports[path] {
+			if to, ok := config.Params[value]; ok {
+				value = to
+			}
+			if placeholders.In(name) {
+				params[name] = value
+			} else {
+				renames[name] = value
+			}
+		}
+
+		// type parameters instantiated by pkg
+		instantiated := make(map[string]string)
+		for id, instance := range info.Instances {
+			obj := originOf(info.Uses[id])
+			if obj == nil || obj.Pkg() == nil || obj.Pkg().Path() != imported.PkgPath {
+				continue
+			}
+			typeParams := typeParamsOf(obj)
+			for i := 0; i < instance.TypeArgs.Len(); i++ {
+				arg := typeArg(instance.TypeArgs.At(i))
+				if arg == "" { // not bound
+					continue
+				}
+				name := typeParams.At(i).Obj().Name()
+				if prev, ok := instantiated[name]; ok && prev != arg {
+					return nil, me(nil, "%s.%s instantiated with different type arguments at %v",
+						path, obj.Name(), config.FileSet.Position(id.Pos()))
+				}
+				instantiated[name] = arg
+			}
+		}
+		for name, value := range instantiated {
+			if _, ok := params[name]; !ok {
+				params[name] = value
+			}
+		}
+		// params of the same names
+		for name, value := range config.Params {
+			if _, ok := params[name]; !ok && placeholders.In(name) {
+				params[name] = value
+			}
+		}
+
+		// names used by pkg
+		usedNames := NewStrSet()
+		for _, obj := range info.Uses {
+			obj = originOf(obj)
+			if obj.Pkg() == nil || obj.Pkg().Path() != imported.PkgPath {
+				continue
+			}
+			switch obj := obj.(type) {
+			case *types.Func:
+				if recv := obj.Type().(*types.Signature).Recv(); recv != nil {
+					t := recv.Type()
+					if ptr, ok := t.(*types.Pointer); ok {
+						t = ptr.Elem()
+					}
+					if named, ok := t.(*types.Named); ok {
+						usedNames.Add(named.Obj().Name() + "." + obj.Name())
+					}
+					continue
+				}
+			case *types.Var:
+				if obj.IsField() {
+					continue
+				}
+			}
+			usedNames.Add(obj.Name())
+		}
+		var uses []string
+		for name := range usedNames {
+			uses = append(uses, name)
+		}
+		sort.Strings(uses)
+
+		buf := new(bytes.Buffer)
+		if err := Copy(Config{
+			From:         imported.PkgPath,
+			Loader:       loader,
+			Params:       params,
+			Renames:      renames,
+			Uses:         uses,
+			HelperSuffix: config.HelperSuffix,
+			Writer:       buf,
+		}); err != nil {
+			return nil, me(err, "compose %s", path)
+		}
+		file, err := parser.ParseFile(loader.FileSet, imported.PkgPath,
+			append([]byte("package "+imported.Name+"\n"), buf.Bytes()...), parser.ParseComments)
+		if err != nil { //NOCOVER
+			return nil, me(err, "parse composed %s", path)
+		}
+
+		paramSet := NewStrSet()
+		for name := range params {
+			paramSet.Add(name)
+		}
+		names, err := expandRenames(renames, imported.Types.Scope().Names(), paramSet, false)
+		if err != nil {
+			return nil, me(err, "compose %s", path)
+		}
+		specialized := NewStrSet()
+		scope := imported.Types.Scope()
+		for _, name := range scope.Names() {
+			typeParams := typeParamsOf(scope.Lookup(name))
+			if typeParams.Len() == 0 {
+				continue
+			}
+			bound := true
+			for i := 0; i < typeParams.Len(); i++ {
+				if _, ok := params[typeParams.At(i).Obj().Name()]; !ok {
+					bound = false
+				}
+			}
+			if bound {
+				specialized.Add(name)
+			}
+		}
+
+		ret[path] = &composition{
+			pkg:         imported,
+			names:       names,
+			specialized: specialized,
+			file:        file,
+		}
+	}
+	return ret, nil
+}
+
+// rewriteComposed replaces references to composed templates in pkg with output names, and drops imports of them
+func rewriteComposed(pkg *packages.Package, compositions map[string]*composition) {
+	if len(compositions) == 0 {
+		return
+	}
+	info := pkg.TypesInfo
+	ref := func(expr ast.Expr) (*ast.Ident, *composition) {
+		sel, ok := expr.(*ast.SelectorExpr)
+		if !ok {
+			return nil, nil
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return nil, nil
+		}
+		pkgName, ok := info.Uses[x].(*types.PkgName)
+		if !ok {
+			return nil, nil
+		}
+		c, ok := compositions[pkgName.Imported().Path()]
+		if !ok {
+			return nil, nil
+		}
+		name := sel.Sel.Name
+		if to, ok := c.names[name]; ok {
+			name = to
+		}
+		return &ast.Ident{
+			Name:    name,
+			NamePos: sel.Pos(),
+		}, c
+	}
+	for _, f := range pkg.Syntax {
+		astutil.Apply(f, func(cursor *astutil.Cursor) bool {
+			switch node := cursor.Node().(type) {
+			case *ast.IndexExpr:
+				if id, c := ref(node.X); id != nil && c.specialized.In(node.X.(*ast.SelectorExpr).Sel.Name) {
+					cursor.Replace(id)
+				}
+			case *ast.IndexListExpr:
+				if id, c := ref(node.X); id != nil && c.specialized.In(node.X.(*ast.SelectorExpr).Sel.Name) {
+					cursor.Replace(id)
+				}
+			case *ast.SelectorExpr:
+				if id, _ := ref(node); id != nil {
+					cursor.Replace(id)
+				}
+			}
+			return true
+		}, nil)
+		f.Decls = filterDecls(f.Decls, func(node interface{}) bool {
+			spec, ok := node.(*ast.ImportSpec)
+			if !ok {
+				return true
+			}
+			path, _ := strconv.Unquote(spec.Path.Value)
+			_, composed := compositions[path]
+			return !composed
+		})
+	}
+}
+
+// mergeComposed returns files of composed declarations, dropping declarations generated by multiple compositions.
+// names declared by pkg or different declarations of the same names are conflicts.
+func mergeComposed(fset *token.FileSet, pkg *packages.Package, compositions map[string]*composition) ([]*ast.File, error) {
+	declared := NewStrSet()
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			for _, name := range declNames(decl) {
+				declared.Add(name)
+			}
+		}
+	}
+	var paths []string
+	for path := range compositions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var files []*ast.File
+	sources := make(map[string]string)
+	from := make(map[string]string)
+	var conflicts []string
+	for _, path := range paths {
+		f := compositions[path].file
+		var decls []ast.Decl
+	next:
+		for _, decl := range f.Decls {
+			for _, name := range declNames(decl) {
+				src := nodeSource(fset, decl)
+				if declared.In(name) {
+					conflicts = append(conflicts, sp("%s from %s", name, path))
+					continue next
+				}
+				if prev, ok := sources[name]; ok {
+					if prev != src {
+						conflicts = append(conflicts, sp("%s from %s and %s", name, from[name], path))
+					}
+					continue next
+				}
+				sources[name] = src
+				from[name] = path
+			}
+			decls = append(decls, decl)
+		}
+		f.Decls = decls
+		files = append(files, f)
+	}
+	if len(conflicts) > 0 {
+		return nil, me(nil, "conflicting composed declarations: %s", strings.Join(conflicts, ", "))
+	}
+	return files, nil
+}
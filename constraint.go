@@ -0,0 +1,355 @@
+package ccg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ConstraintError reports a parameter whose supplied type violates the constraint of the placeholder
+type ConstraintError struct {
+	Param     string         // parameter name
+	Type      string         // supplied type
+	Method    string         // missing or mismatched method
+	Operation string         // operation not supported by the supplied type
+	Pos       token.Position // position of the violated operation
+	Reason    string         // description of the violation
+}
+
+func (e *ConstraintError) Error() string {
+	return sp("parameter %s: %s: %s", e.Param, e.Type, e.Reason)
+}
+
+// checkConstraints type-checks substituted types against placeholder interfaces and type parameter constraints.
+// supplied types are resolved by resolveType, a constraint not checkable for an unresolvable type is reported as violated.
+func checkConstraints(
+	fset *token.FileSet,
+	pkg *packages.Package,
+	params map[string]string,
+	specialized ObjectSet,
+	bindings map[types.Object]string,
+	resolve func(expr string) (types.Type, error),
+) error {
+	qualifier := types.RelativeTo(pkg.Types)
+	unchecked := func(name, to string, err error) error {
+		return &ConstraintError{
+			Param:  name,
+			Type:   to,
+			Reason: sp("constraint not checked: %v", err),
+		}
+	}
+
+	// types bound to placeholders
+	bound := make(map[types.Object]types.Type)
+	unresolved := make(map[types.Object]error)
+	for name, to := range params {
+		obj, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if t, err := resolve(to); err != nil {
+			unresolved[obj] = err
+		} else {
+			bound[obj] = t
+		}
+	}
+
+	// placeholder interfaces
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		to := params[name]
+		obj, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		iface, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok {
+			continue
+		}
+
+		// operations requiring a comparable type, comparisons to nil are valid for all types that can be nil
+		var violation *ConstraintError
+		isParam := func(expr ast.Expr) bool {
+			return types.Identical(pkg.TypesInfo.TypeOf(expr), obj.Type())
+		}
+		isNil := func(expr ast.Expr) bool {
+			return pkg.TypesInfo.Types[expr].IsNil()
+		}
+		for _, f := range pkg.Syntax {
+			ast.Inspect(f, func(node ast.Node) bool {
+				if violation != nil {
+					return false
+				}
+				switch node := node.(type) {
+				case *ast.BinaryExpr:
+					if (node.Op == token.EQL || node.Op == token.NEQ) &&
+						(isParam(node.X) && !isNil(node.Y) || isParam(node.Y) && !isNil(node.X)) {
+						violation = &ConstraintError{
+							Param:     name,
+							Type:      to,
+							Operation: node.Op.String(),
+							Pos:       fset.Position(node.OpPos),
+							Reason:    sp("not comparable, used with %s at %v", node.Op, fset.Position(node.OpPos)),
+						}
+					}
+				case *ast.MapType:
+					if isParam(node.Key) {
+						violation = &ConstraintError{
+							Param:     name,
+							Type:      to,
+							Operation: "map key",
+							Pos:       fset.Position(node.Key.Pos()),
+							Reason:    sp("not comparable, used as map key at %v", fset.Position(node.Key.Pos())),
+						}
+					}
+				}
+				return true
+			})
+		}
+		if iface.NumMethods() == 0 && violation == nil {
+			continue
+		}
+		t, ok := bound[obj]
+		if !ok {
+			return unchecked(name, to, unresolved[obj])
+		}
+
+		for i := 0; i < iface.NumMethods(); i++ {
+			method := iface.Method(i)
+			found, _, _ := types.LookupFieldOrMethod(t, false, pkg.Types, method.Name())
+			fn, ok := found.(*types.Func)
+			if !ok {
+				return &ConstraintError{
+					Param:  name,
+					Type:   to,
+					Method: method.Name(),
+					Reason: sp("missing method %s", method.Name()),
+				}
+			}
+			if other := mentioned(method.Type(), unresolved); other != nil {
+				return unchecked(other.Name(), params[other.Name()], unresolved[other])
+			}
+			expected := substType(method.Type(), bound).(*types.Signature)
+			got := fn.Type().(*types.Signature)
+			got = types.NewSignatureType(nil, nil, nil, got.Params(), got.Results(), got.Variadic())
+			if !types.Identical(expected, got) {
+				return &ConstraintError{
+					Param:  name,
+					Type:   to,
+					Method: method.Name(),
+					Reason: sp("wrong signature for method %s: have %s, want %s", method.Name(),
+						types.TypeString(got, qualifier), types.TypeString(expected, qualifier)),
+				}
+			}
+		}
+		if violation != nil && !types.Comparable(t) {
+			return violation
+		}
+	}
+
+	// type parameter constraints
+	for obj := range specialized {
+		typeParams := typeParamsOf(obj)
+		args := make([]types.Type, typeParams.Len())
+		for i := range args {
+			param := typeParams.At(i)
+			to := bindings[param.Obj()]
+			t, err := resolve(to)
+			if err != nil {
+				if iface, ok := param.Constraint().Underlying().(*types.Interface); !ok || !iface.Empty() {
+					return unchecked(param.Obj().Name(), to, err)
+				}
+				t = types.Typ[types.Invalid] // satisfies any
+			}
+			args[i] = t
+		}
+		_, err := types.Instantiate(nil, obj.Type(), args, true)
+		if err == nil {
+			continue
+		}
+		argErr, ok := err.(*types.ArgumentError)
+		if !ok { //NOCOVER
+			return err
+		}
+		param := typeParams.At(argErr.Index)
+		e := &ConstraintError{
+			Param:  param.Obj().Name(),
+			Type:   bindings[param.Obj()],
+			Reason: argErr.Err.Error(),
+		}
+		if iface, ok := param.Constraint().Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumMethods(); i++ {
+				name := iface.Method(i).Name()
+				if found, _, _ := types.LookupFieldOrMethod(args[argErr.Index], false, pkg.Types, name); found == nil {
+					e.Method = name
+					e.Reason = sp("missing method %s", name)
+					break
+				}
+			}
+		}
+		return e
+	}
+
+	return nil
+}
+
+// resolveType returns the resolver of types supplied to placeholders.
+// types are resolved in the template package, with package names of imports (import paths to names) or standard library packages,
+// and names declared in the package in outputDir if any.
+func resolveType(fset *token.FileSet, loader *Loader, pkg *packages.Package, imports map[string]string, outputDir string) func(string) (types.Type, error) {
+	paths := make(map[string]string) // package names to import paths
+	for path, name := range imports {
+		paths[name] = path
+	}
+	imported := func(name string) *types.Package {
+		path, ok := paths[name]
+		if !ok {
+			path = name
+		}
+		if p, ok := pkg.Imports[path]; ok {
+			return p.Types
+		}
+		p, err := loader.Load(path)
+		if err != nil {
+			return nil
+		}
+		return p.Types
+	}
+	var destination *types.Scope
+	loaded := false
+	destinationScope := func() *types.Scope {
+		if loaded {
+			return destination
+		}
+		loaded = true
+		if outputDir == "" {
+			return nil
+		}
+		pkgs, err := packages.Load(&packages.Config{
+			Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedTypes | packages.NeedSyntax,
+			Dir:  outputDir,
+		}, ".")
+		if err == nil && len(pkgs) == 1 && pkgs[0].Types != nil && pkgs[0].PkgPath != pkg.PkgPath {
+			destination = pkgs[0].Types.Scope()
+		}
+		return destination
+	}
+
+	return func(expr string) (types.Type, error) {
+		if tv, ok, err := evalValue(fset, pkg, expr); err == nil && ok {
+			if !tv.IsType() {
+				return nil, fmt.Errorf("%s is not a type", expr)
+			}
+			return tv.Type, nil
+		}
+		node, err := parser.ParseExpr(expr)
+		if err != nil {
+			return nil, err
+		}
+		p := types.NewPackage(pkg.PkgPath, pkg.Name)
+		scope := p.Scope()
+		for _, name := range pkg.Types.Scope().Names() {
+			scope.Insert(pkg.Types.Scope().Lookup(name))
+		}
+		ast.Inspect(node, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.SelectorExpr:
+				if id, ok := node.X.(*ast.Ident); ok && scope.Lookup(id.Name) == nil {
+					if imported := imported(id.Name); imported != nil {
+						scope.Insert(types.NewPkgName(token.NoPos, p, id.Name, imported))
+					}
+					return false
+				}
+			case *ast.Ident:
+				if scope.Lookup(node.Name) == nil && types.Universe.Lookup(node.Name) == nil {
+					if destination := destinationScope(); destination != nil {
+						if obj := destination.Lookup(node.Name); obj != nil {
+							scope.Insert(obj)
+						}
+					}
+				}
+			}
+			return true
+		})
+		tv, err := types.Eval(fset, p, token.NoPos, expr)
+		if err != nil {
+			return nil, err
+		}
+		if !tv.IsType() {
+			return nil, fmt.Errorf("%s is not a type", expr)
+		}
+		return tv.Type, nil
+	}
+}
+
+// mentioned returns a placeholder of placeholders referenced in t, or nil
+func mentioned(t types.Type, placeholders map[types.Object]error) types.Object {
+	switch t := t.(type) {
+	case *types.Named:
+		if _, ok := placeholders[t.Obj()]; ok {
+			return t.Obj()
+		}
+	case *types.Pointer:
+		return mentioned(t.Elem(), placeholders)
+	case *types.Slice:
+		return mentioned(t.Elem(), placeholders)
+	case *types.Array:
+		return mentioned(t.Elem(), placeholders)
+	case *types.Map:
+		if obj := mentioned(t.Key(), placeholders); obj != nil {
+			return obj
+		}
+		return mentioned(t.Elem(), placeholders)
+	case *types.Chan:
+		return mentioned(t.Elem(), placeholders)
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if obj := mentioned(tuple.At(i).Type(), placeholders); obj != nil {
+					return obj
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// substType replaces placeholders in t with types bound to them
+func substType(t types.Type, bound map[types.Object]types.Type) types.Type {
+	switch t := t.(type) {
+	case *types.Named:
+		if to, ok := bound[t.Obj()]; ok {
+			return to
+		}
+	case *types.Pointer:
+		return types.NewPointer(substType(t.Elem(), bound))
+	case *types.Slice:
+		return types.NewSlice(substType(t.Elem(), bound))
+	case *types.Array:
+		return types.NewArray(substType(t.Elem(), bound), t.Len())
+	case *types.Map:
+		return types.NewMap(substType(t.Key(), bound), substType(t.Elem(), bound))
+	case *types.Chan:
+		return types.NewChan(t.Dir(), substType(t.Elem(), bound))
+	case *types.Signature:
+		subst := func(tuple *types.Tuple) *types.Tuple {
+			vars := make([]*types.Var, tuple.Len())
+			for i := range vars {
+				v := tuple.At(i)
+				vars[i] = types.NewParam(v.Pos(), v.Pkg(), v.Name(), substType(v.Type(), bound))
+			}
+			return types.NewTuple(vars...)
+		}
+		return types.NewSignatureType(nil, nil, nil, subst(t.Params()), subst(t.Results()), t.Variadic())
+	}
+	return t
+}
@@ -0,0 +1,398 @@
+package ccg
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// declDeps returns the dependency graph of declarations: top-level objects and methods of pkg referenced by each function, method, type and value,
+// and methods of pkg needed to satisfy interfaces that values are converted to.
+func declDeps(info *types.Info, pkg *types.Package, decls []ast.Decl) map[types.Object]ObjectSet {
+	isDecl := func(obj types.Object) bool {
+		if obj == nil || obj.Pkg() != pkg {
+			return false
+		}
+		if fn, ok := obj.(*types.Func); ok && fn.Type().(*types.Signature).Recv() != nil {
+			return true
+		}
+		return obj.Parent() == pkg.Scope()
+	}
+
+	// methods of from satisfying the interface to
+	implements := func(set ObjectSet, from, to types.Type) {
+		iface, ok := to.Underlying().(*types.Interface)
+		if !ok || types.IsInterface(from) {
+			return
+		}
+		for i := 0; i < iface.NumMethods(); i++ {
+			method, _, _ := types.LookupFieldOrMethod(from, true, pkg, iface.Method(i).Name())
+			if obj := originOf(method); isDecl(obj) {
+				set.Add(obj)
+			}
+		}
+	}
+
+	collect := func(set ObjectSet, node ast.Node) {
+		ast.Inspect(node, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok {
+				if obj := originOf(info.ObjectOf(id)); isDecl(obj) {
+					set.Add(obj)
+				}
+			}
+			return true
+		})
+		conversions(info, node, func(from, to types.Type) {
+			implements(set, from, to)
+		})
+	}
+
+	deps := make(map[types.Object]ObjectSet)
+	add := func(id *ast.Ident, nodes ...ast.Node) ObjectSet {
+		set := NewObjectSet()
+		obj := info.ObjectOf(id)
+		if obj == nil {
+			return set
+		}
+		for _, node := range nodes {
+			collect(set, node)
+		}
+		delete(set, obj)
+		deps[obj] = set
+		return set
+	}
+	for _, decl := range decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			add(decl.Name, decl)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add(spec.Name, spec)
+				case *ast.ValueSpec:
+					for i, name := range spec.Names {
+						values := spec.Values
+						if len(values) == len(spec.Names) {
+							values = values[i : i+1]
+						}
+						nodes := []ast.Node{}
+						if spec.Type != nil {
+							nodes = append(nodes, spec.Type)
+						}
+						for _, value := range values {
+							nodes = append(nodes, value)
+						}
+						set := add(name, nodes...)
+						if spec.Type != nil && len(spec.Values) == len(spec.Names) { // converted to the declared type
+							if from, to := info.TypeOf(values[0]), info.TypeOf(spec.Type); from != nil && to != nil {
+								implements(set, from, to)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// conversions calls fn with types of values and the types they are assigned or converted to in node.
+// assignments to interface types are implicit conversions.
+func conversions(info *types.Info, node ast.Node, fn func(from, to types.Type)) {
+	var results *types.Tuple // of the enclosing function
+	convert := func(expr ast.Expr, to types.Type) {
+		if from := info.TypeOf(expr); from != nil && to != nil {
+			fn(from, to)
+		}
+	}
+
+	var inspect func(node ast.Node) bool
+	inspect = func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			var body *ast.BlockStmt
+			var sig *types.Signature
+			switch node := node.(type) {
+			case *ast.FuncDecl:
+				body = node.Body
+				if obj := info.ObjectOf(node.Name); obj != nil {
+					sig, _ = obj.Type().(*types.Signature)
+				}
+			case *ast.FuncLit:
+				body = node.Body
+				sig, _ = info.TypeOf(node).(*types.Signature)
+			}
+			outer := results
+			results = nil
+			if sig != nil {
+				results = sig.Results()
+			}
+			if body != nil {
+				ast.Inspect(body, inspect)
+			}
+			results = outer
+			return false
+
+		case *ast.ReturnStmt:
+			if results != nil && len(node.Results) == results.Len() {
+				for i, expr := range node.Results {
+					convert(expr, results.At(i).Type())
+				}
+			}
+
+		case *ast.AssignStmt:
+			if node.Tok == token.ASSIGN && len(node.Lhs) == len(node.Rhs) {
+				for i, expr := range node.Rhs {
+					convert(expr, info.TypeOf(node.Lhs[i]))
+				}
+			}
+
+		case *ast.ValueSpec:
+			if node.Type != nil && len(node.Values) == len(node.Names) {
+				for _, expr := range node.Values {
+					convert(expr, info.TypeOf(node.Type))
+				}
+			}
+
+		case *ast.SendStmt:
+			if ch, ok := typeUnder(info.TypeOf(node.Chan)).(*types.Chan); ok {
+				convert(node.Value, ch.Elem())
+			}
+
+		case *ast.CallExpr:
+			if tv, ok := info.Types[node.Fun]; ok && tv.IsType() { // explicit conversion
+				if len(node.Args) == 1 {
+					convert(node.Args[0], tv.Type)
+				}
+				break
+			}
+			sig, ok := typeUnder(info.TypeOf(node.Fun)).(*types.Signature)
+			if !ok || node.Ellipsis.IsValid() {
+				break
+			}
+			params := sig.Params()
+			for i, arg := range node.Args {
+				switch {
+				case sig.Variadic() && i >= params.Len()-1:
+					if slice, ok := params.At(params.Len() - 1).Type().(*types.Slice); ok {
+						convert(arg, slice.Elem())
+					}
+				case i < params.Len():
+					convert(arg, params.At(i).Type())
+				}
+			}
+
+		case *ast.CompositeLit:
+			t := typeUnder(info.TypeOf(node))
+			if ptr, ok := t.(*types.Pointer); ok { // elided &T in composite literals
+				t = ptr.Elem().Underlying()
+			}
+			for i, elt := range node.Elts {
+				key, value := ast.Expr(nil), elt
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					key, value = kv.Key, kv.Value
+				}
+				switch t := t.(type) {
+				case *types.Struct:
+					if id, ok := key.(*ast.Ident); ok {
+						if field, ok := info.ObjectOf(id).(*types.Var); ok {
+							convert(value, field.Type())
+						}
+					} else if key == nil && i < t.NumFields() {
+						convert(value, t.Field(i).Type())
+					}
+				case *types.Slice:
+					convert(value, t.Elem())
+				case *types.Array:
+					convert(value, t.Elem())
+				case *types.Map:
+					if key != nil {
+						convert(key, t.Key())
+					}
+					convert(value, t.Elem())
+				}
+			}
+		}
+		return true
+	}
+	ast.Inspect(node, inspect)
+}
+
+// typeUnder returns the underlying type of t, or nil
+func typeUnder(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.Underlying()
+}
+
+// Graph is the dependency graph of declarations of a template package, with the closure of uses if any.
+// declarations are named as in the template, methods as Type.Method.
+type Graph struct {
+	Package string              `json:"package"`
+	Deps    map[string][]string `json:"deps"` // declarations to declarations they depend on, in name order
+	Uses    []string            `json:"uses,omitempty"`
+	Closure []string            `json:"closure,omitempty"` // declarations generated for Uses, in name order
+	Why     map[string][]string `json:"why,omitempty"`     // shortest paths from a requested declaration to declarations of Closure
+}
+
+// Dependencies returns the dependency graph of declarations of the template package, and the closure of Uses if any.
+// generation options other than From, Dir, Loader, FileSet, Renames and Uses are not used. Uses may refer to explicitly renamed names.
+func Dependencies(config Config) (*Graph, error) {
+	loader := config.Loader
+	if loader == nil {
+		loader = NewLoader(config.FileSet, config.Dir)
+	}
+	pkg, err := loader.Load(config.From)
+	if err != nil {
+		return nil, me(err, "load package")
+	}
+	var decls []ast.Decl
+	for _, f := range pkg.Syntax {
+		decls = append(decls, f.Decls...)
+	}
+	deps := declDeps(pkg.TypesInfo, pkg.Types, decls)
+
+	graph := &Graph{
+		Package: pkg.PkgPath,
+		Deps:    make(map[string][]string),
+		Uses:    config.Uses,
+	}
+	for obj, set := range deps {
+		names := []string{}
+		for dep := range set {
+			names = append(names, declName(dep))
+		}
+		sort.Strings(names)
+		graph.Deps[declName(obj)] = names
+	}
+	if len(config.Uses) == 0 {
+		return graph, nil
+	}
+
+	renamed := make(map[string]string)
+	for from, to := range config.Renames {
+		if !isRenamePattern(from) {
+			renamed[to] = from
+		}
+	}
+	requested, err := parseUses(loader, pkg, config.Uses, renamed)
+	if err != nil {
+		return nil, err
+	}
+
+	// breadth-first from requested declarations, in name order
+	var queue []types.Object
+	for obj := range requested {
+		queue = append(queue, obj)
+	}
+	byName := func(objs []types.Object) {
+		sort.Slice(objs, func(i, j int) bool {
+			return declName(objs[i]) < declName(objs[j])
+		})
+	}
+	byName(queue)
+	parents := make(map[types.Object]types.Object)
+	for _, obj := range queue {
+		parents[obj] = nil
+	}
+	for len(queue) > 0 {
+		obj := queue[0]
+		queue = queue[1:]
+		var next []types.Object
+		for dep := range deps[obj] {
+			if _, ok := parents[dep]; !ok {
+				parents[dep] = obj
+				next = append(next, dep)
+			}
+		}
+		byName(next)
+		queue = append(queue, next...)
+	}
+
+	graph.Why = make(map[string][]string)
+	for obj := range parents {
+		name := declName(obj)
+		graph.Closure = append(graph.Closure, name)
+		var path []string
+		for o := obj; o != nil; o = parents[o] {
+			path = append([]string{declName(o)}, path...)
+		}
+		graph.Why[name] = path
+	}
+	sort.Strings(graph.Closure)
+	return graph, nil
+}
+
+// declName returns the name of a top-level object or method, as Type.Method for methods
+func declName(obj types.Object) string {
+	if fn, ok := obj.(*types.Func); ok {
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			t := types.Unalias(recv.Type())
+			if ptr, ok := t.(*types.Pointer); ok {
+				t = types.Unalias(ptr.Elem())
+			}
+			if named, ok := t.(*types.Named); ok {
+				return named.Obj().Name() + "." + fn.Name()
+			}
+		}
+	}
+	return obj.Name()
+}
+
+// names returns declarations of the graph in name order
+func (g *Graph) names() []string {
+	var names []string
+	for name := range g.Deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns dependencies of declarations, one declaration per line, and paths to declarations of the closure if any
+func (g *Graph) String() string {
+	buf := new(strings.Builder)
+	for _, name := range g.names() {
+		buf.WriteString(name + ":")
+		for _, dep := range g.Deps[name] {
+			buf.WriteString(" " + dep)
+		}
+		buf.WriteString("\n")
+	}
+	if len(g.Uses) > 0 {
+		buf.WriteString(sp("\nclosure of %s:\n", strings.Join(g.Uses, ",")))
+		for _, name := range g.Closure {
+			buf.WriteString(sp("%s: %s\n", name, strings.Join(g.Why[name], " -> ")))
+		}
+	}
+	return buf.String()
+}
+
+// DOT returns the graph in the Graphviz DOT language. declarations of the closure are filled, requested ones are bold
+func (g *Graph) DOT() string {
+	buf := new(strings.Builder)
+	buf.WriteString(sp("digraph %s {\n", strconv.Quote(g.Package)))
+	for _, name := range g.names() {
+		path, ok := g.Why[name]
+		switch {
+		case !ok:
+			buf.WriteString(sp("\t%s;\n", strconv.Quote(name)))
+		case len(path) == 1:
+			buf.WriteString(sp("\t%s [style=\"filled,bold\"];\n", strconv.Quote(name)))
+		default:
+			buf.WriteString(sp("\t%s [style=filled];\n", strconv.Quote(name)))
+		}
+	}
+	for _, name := range g.names() {
+		for _, dep := range g.Deps[name] {
+			buf.WriteString(sp("\t%s -> %s;\n", strconv.Quote(name), strconv.Quote(dep)))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
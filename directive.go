@@ -0,0 +1,200 @@
+package ccg
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	// InstantiateDirective requests an instantiation in the destination package, in the form of
+	//  //ccg:instantiate <template> Name=Value...
+	InstantiateDirective = "//ccg:instantiate"
+
+	// GeneratedFile is the file in each package that directive instantiations are written to
+	GeneratedFile = "ccg_generated.go"
+)
+
+// paramNames returns names in the template bound as params instead of renames:
+// type parameters, interface types, constants declared with zero values and variables declared without values
+func paramNames(pkg *packages.Package) StrSet {
+	ret := NewStrSet()
+	addTypeParams := func(params *types.TypeParamList) {
+		for i := 0; i < params.Len(); i++ {
+			ret.Add(params.At(i).Obj().Name())
+		}
+	}
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.TypeName:
+			if params := typeParamsOf(obj); params != nil {
+				addTypeParams(params)
+			}
+			if types.IsInterface(obj.Type()) {
+				ret.Add(name)
+			}
+		case *types.Func:
+			addTypeParams(typeParamsOf(obj))
+		case *types.Const:
+			if isZero(obj.Val()) {
+				ret.Add(name)
+			}
+		}
+	}
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.ValueSpec)
+				if len(spec.Values) > 0 {
+					continue
+				}
+				for _, name := range spec.Names {
+					ret.Add(name.Name)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// isZero reports whether v is the zero value of its kind, as 0, "" or false
+func isZero(v constant.Value) bool {
+	switch v.Kind() {
+	case constant.Int, constant.Float, constant.Complex:
+		return constant.Sign(v) == 0
+	case constant.String:
+		return constant.StringVal(v) == ""
+	case constant.Bool:
+		return !constant.BoolVal(v)
+	}
+	return false
+}
+
+// Discover collects instantiate directives in packages matching patterns.
+// Each directive becomes a job writing to GeneratedFile in the directive's package.
+func Discover(dir string, patterns ...string) (*Manifest, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles,
+		Dir:  dir,
+	}, patterns...)
+	if err != nil {
+		return nil, me(err, "load packages")
+	}
+	manifest := new(Manifest)
+	fset := new(token.FileSet)
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return nil, me(pkg.Errors[0], "load package %s", pkg.PkgPath)
+		}
+		for _, filename := range pkg.GoFiles {
+			if filepath.Base(filename) == GeneratedFile {
+				continue
+			}
+			f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+			if err != nil {
+				return nil, me(err, "parse %s", filename)
+			}
+			for _, group := range f.Comments {
+				for _, comment := range group.List {
+					if !strings.HasPrefix(comment.Text, InstantiateDirective+" ") {
+						continue
+					}
+					job, err := parseDirective(filepath.Dir(filename), comment.Text)
+					if err != nil {
+						return nil, me(err, "%v", fset.Position(comment.Pos()))
+					}
+					manifest.Jobs = append(manifest.Jobs, job)
+				}
+			}
+		}
+	}
+	return manifest, nil
+}
+
+func parseDirective(dir string, text string) (job Job, err error) {
+	fields, err := splitDirective(strings.TrimPrefix(text, InstantiateDirective))
+	if err != nil {
+		return job, err
+	}
+	if len(fields) == 0 {
+		return job, me(nil, "no template package specified")
+	}
+	job.From = fields[0]
+	if strings.HasPrefix(job.From, ".") { // relative to the package
+		job.From = filepath.Join(dir, job.From)
+	}
+	job.Args = make(map[string]string)
+	for _, field := range fields[1:] {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			return job, me(nil, "invalid argument %s", field)
+		}
+		name, value := pair[0], pair[1]
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "`") {
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return job, me(err, "invalid argument %s", field)
+			}
+		}
+		if isRenamePattern(name) {
+			if job.Renames == nil {
+				job.Renames = make(map[string]string)
+			}
+			job.Renames[name] = value
+			continue
+		}
+		job.Args[name] = value
+	}
+	job.Output = filepath.Join(dir, GeneratedFile)
+	return
+}
+
+// splitDirective splits arguments of a directive by spaces, except quoted ones, as in N="1 << 4"
+func splitDirective(text string) ([]string, error) {
+	var fields []string
+	var field []rune
+	var quote rune // of the quoted string being read
+	escaped := false
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\' && quote != '`':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+		case r == '"' || r == '`':
+			quote = r
+		case unicode.IsSpace(r):
+			if len(field) > 0 {
+				fields = append(fields, string(field))
+				field = field[:0]
+			}
+			continue
+		}
+		field = append(field, r)
+	}
+	if quote != 0 {
+		return nil, me(nil, "unterminated quote in %s", strings.TrimSpace(text))
+	}
+	if len(field) > 0 {
+		fields = append(fields, string(field))
+	}
+	return fields, nil
+}
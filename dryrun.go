@@ -0,0 +1,119 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// Summary lists changes of top-level declarations between two versions of a file
+type Summary struct {
+	Added     []string
+	Replaced  []string
+	Unchanged []string
+	Deleted   []string
+
+	Unparsable bool // the current file does not parse, declarations are not compared
+}
+
+func (s *Summary) String() string {
+	if s.Unparsable {
+		return "existing file unparsable\n"
+	}
+	buf := new(bytes.Buffer)
+	for _, part := range []struct {
+		title string
+		names []string
+	}{
+		{"added", s.Added},
+		{"replaced", s.Replaced},
+		{"unchanged", s.Unchanged},
+		{"deleted", s.Deleted},
+	} {
+		if len(part.names) == 0 {
+			continue
+		}
+		buf.WriteString(sp("%s: %s\n", part.title, strings.Join(part.names, ", ")))
+	}
+	return buf.String()
+}
+
+// declSources returns formatted sources of top-level declarations by name, imports and init functions excluded
+func declSources(src []byte) (map[string]string, error) {
+	ret := make(map[string]string)
+	if len(src) == 0 {
+		return ret, nil
+	}
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	formatNode := func(node interface{}) string {
+		buf := new(bytes.Buffer)
+		format.Node(buf, fset, node)
+		return buf.String()
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			name := getFuncDeclName(decl)
+			if name == "init" {
+				continue
+			}
+			ret[name] = formatNode(decl)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					ret[spec.Name.Name] = formatNode(spec)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						ret[name.Name] = decl.Tok.String() + " " + formatNode(spec)
+					}
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// summarize compares top-level declarations of the current and the new source of a file
+func summarize(current, output []byte) (*Summary, error) {
+	before, err := declSources(current)
+	if err != nil {
+		return &Summary{
+			Unparsable: true,
+		}, nil
+	}
+	after, err := declSources(output)
+	if err != nil { //NOCOVER
+		return nil, me(err, "parse output")
+	}
+	summary := new(Summary)
+	for name, src := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			summary.Added = append(summary.Added, name)
+		case old != src:
+			summary.Replaced = append(summary.Replaced, name)
+		default:
+			summary.Unchanged = append(summary.Unchanged, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			summary.Deleted = append(summary.Deleted, name)
+		}
+	}
+	sort.Strings(summary.Added)
+	sort.Strings(summary.Replaced)
+	sort.Strings(summary.Unchanged)
+	sort.Strings(summary.Deleted)
+	return summary, nil
+}
@@ -0,0 +1,148 @@
+package ccg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Loader loads template packages, caching them for repeated Copy calls
+type Loader struct {
+	FileSet *token.FileSet
+	Dir     string
+	loaded  map[string]*packages.Package
+}
+
+func NewLoader(fset *token.FileSet, dir string) *Loader {
+	if fset == nil {
+		fset = new(token.FileSet)
+	}
+	return &Loader{
+		FileSet: fset,
+		Dir:     dir,
+		loaded:  make(map[string]*packages.Package),
+	}
+}
+
+// Load returns the package of path.
+// Copy modifies syntax trees, so packages already loaded are re-parsed and re-checked against the cached imports.
+func (l *Loader) Load(path string) (*packages.Package, error) {
+	return l.load(path, false)
+}
+
+// LoadTests returns the package of path with its _test.go files of the same package
+func (l *Loader) LoadTests(path string) (*packages.Package, error) {
+	return l.load(path, true)
+}
+
+func (l *Loader) load(path string, tests bool) (*packages.Package, error) {
+	key := path
+	if tests {
+		key += " [tests]"
+	}
+	pkg, ok := l.loaded[key]
+	if !ok {
+		pkg, err := loadPackage(l.FileSet, l.Dir, path, tests)
+		if err != nil {
+			return nil, err
+		}
+		l.loaded[key] = pkg
+		return pkg, nil
+	}
+
+	ret := &packages.Package{
+		ID:              pkg.ID,
+		Name:            pkg.Name,
+		PkgPath:         pkg.PkgPath,
+		GoFiles:         pkg.GoFiles,
+		CompiledGoFiles: pkg.CompiledGoFiles,
+		Imports:         pkg.Imports,
+		Fset:            l.FileSet,
+		TypesSizes:      pkg.TypesSizes,
+		Module:          pkg.Module,
+	}
+	for _, filename := range pkg.CompiledGoFiles {
+		f, err := parser.ParseFile(l.FileSet, filename, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		ret.Syntax = append(ret.Syntax, f)
+	}
+	imported := make(map[string]*types.Package)
+	for _, p := range pkg.Types.Imports() {
+		imported[p.Path()] = p
+	}
+	ret.TypesInfo = &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Instances:  make(map[*ast.Ident]types.Instance),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if p, ok := imported[path]; ok {
+				return p, nil
+			}
+			return nil, fmt.Errorf("package %s not imported by %s", path, pkg.PkgPath)
+		}),
+		Sizes: pkg.TypesSizes,
+	}
+	var err error
+	ret.Types, err = conf.Check(pkg.PkgPath, l.FileSet, ret.Syntax, ret.TypesInfo)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (fn importerFunc) Import(path string) (*types.Package, error) {
+	return fn(path)
+}
+
+func loadPackage(fset *token.FileSet, dir string, path string, tests bool) (*packages.Package, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports |
+			packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo | packages.NeedTypesSizes |
+			packages.NeedModule,
+		Dir:   dir,
+		Fset:  fset,
+		Tests: tests,
+	}, path)
+	if err != nil {
+		return nil, err
+	}
+	if tests {
+		pkgs = testVariants(pkgs)
+		if len(pkgs) == 0 {
+			return nil, fmt.Errorf("%s has no test files", path)
+		}
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s matches %d packages", path, len(pkgs))
+	}
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 {
+		return nil, pkg.Errors[0]
+	}
+	return pkg, nil
+}
+
+// testVariants returns packages compiled with their _test.go files, excluding external test packages
+func testVariants(pkgs []*packages.Package) (ret []*packages.Package) {
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test]") && !strings.HasSuffix(pkg.Name, "_test") {
+			ret = append(ret, pkg)
+		}
+	}
+	return
+}
@@ -0,0 +1,226 @@
+package ccg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest lists template instantiations to be generated in one batch
+type Manifest struct {
+	Jobs []Job `yaml:"jobs" toml:"jobs"`
+
+	// directory to resolve template packages and output files in
+	Dir string `yaml:"-" toml:"-"`
+}
+
+// Job is an instantiation of a template package
+type Job struct {
+	From    string            `yaml:"from" toml:"from"`
+	Params  map[string]string `yaml:"params" toml:"params"`
+	Renames map[string]string `yaml:"renames" toml:"renames"`
+	Args    map[string]string `yaml:"args" toml:"args"`
+	Uses    []string          `yaml:"uses" toml:"uses"`
+	Suffix  string            `yaml:"helper_suffix" toml:"helper_suffix"`
+	Package string            `yaml:"package" toml:"package"`
+	Output  string            `yaml:"output" toml:"output"`
+
+	RenameUnexported bool `yaml:"rename_unexported" toml:"rename_unexported"`
+	Simplify         bool `yaml:"simplify" toml:"simplify"`
+	LineDirectives   bool `yaml:"line_directives" toml:"line_directives"`
+
+	// generate one file per template file, named after Output, see splitOutput
+	Split bool `yaml:"split" toml:"split"`
+	// generate only declarations of this template file
+	File string `yaml:"file" toml:"file"`
+	// also generate the test file of Output from _test.go files of the template package, see testOutput
+	Tests bool `yaml:"tests" toml:"tests"`
+	// generate only declarations of _test.go files
+	TestFiles bool `yaml:"test_files" toml:"test_files"`
+	// replace declarations of an output file without provenances or markers, see Config.Adopt
+	Adopt bool `yaml:"adopt" toml:"adopt"`
+}
+
+// LoadManifest reads a YAML or TOML manifest, paths in it are relative to the manifest file
+func LoadManifest(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, me(err, "read manifest")
+	}
+	manifest := new(Manifest)
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, manifest)
+	case ".toml":
+		err = toml.Unmarshal(content, manifest)
+	default:
+		return nil, me(nil, "unknown manifest format %s", path)
+	}
+	if err != nil {
+		return nil, me(err, "decode manifest %s", path)
+	}
+	manifest.Dir = filepath.Dir(path)
+	return manifest, nil
+}
+
+// Generate executes all jobs and returns contents of output files.
+// Template packages are loaded once, and jobs sharing an output file are merged in order.
+func (m *Manifest) Generate(verify bool) (map[string][]byte, error) {
+	loader := NewLoader(nil, m.Dir)
+
+	// expand split jobs to one job per template file, and test jobs
+	var expanded []Job
+	for i, job := range m.Jobs {
+		if job.From == "" {
+			return nil, me(nil, "job %d: no template package specified", i)
+		}
+		if job.Output == "" {
+			return nil, me(nil, "job %d: no output file specified", i)
+		}
+		if job.Split {
+			pkg, err := loader.Load(job.From)
+			if err != nil {
+				return nil, me(err, "job %d: load template", i)
+			}
+			main := mainFile(loader.FileSet, pkg)
+			for _, f := range pkg.Syntax {
+				file := job
+				file.Split = false
+				file.Tests = false
+				file.File = fileName(loader.FileSet, f)
+				file.Output = splitOutput(job.Output, pkg, main, file.File)
+				expanded = append(expanded, file)
+			}
+		} else {
+			main := job
+			main.Tests = false
+			expanded = append(expanded, main)
+		}
+		if job.Tests {
+			tests := job
+			tests.Split = false
+			tests.Tests = false
+			tests.TestFiles = true
+			tests.Output = testOutput(job.Output)
+			expanded = append(expanded, tests)
+		}
+	}
+
+	// group jobs by output file
+	var outputs []string
+	jobs := make(map[string][]Job)
+	for _, job := range expanded {
+		output := filepath.Join(m.Dir, job.Output)
+		if _, ok := jobs[output]; !ok {
+			outputs = append(outputs, output)
+		}
+		jobs[output] = append(jobs[output], job)
+	}
+
+	ret := make(map[string][]byte)
+	origins := make(map[string]map[string]token.Position)
+	for _, output := range outputs {
+		var existing []*ast.File
+		exists := false
+		if content, err := os.ReadFile(output); err == nil {
+			exists = true
+			if f, err := parser.ParseFile(loader.FileSet, output, content, parser.ParseComments); err == nil {
+				existing = append(existing, f)
+			}
+		}
+		var pkgName string
+		for _, job := range jobs[output] {
+			if job.Package != "" {
+				pkgName = job.Package
+				break
+			}
+		}
+		if pkgName == "" {
+			name, err := packageName(filepath.Dir(output))
+			if err != nil {
+				return nil, me(err, "detect package of %s", output)
+			}
+			pkgName = name
+		}
+		var src []byte
+		origins[output] = make(map[string]token.Position)
+		for _, job := range jobs[output] {
+			result, err := Instantiate(Config{
+				From:             job.From,
+				Loader:           loader,
+				Params:           job.Params,
+				Renames:          job.Renames,
+				Args:             job.Args,
+				Uses:             job.Uses,
+				HelperSuffix:     job.Suffix,
+				RenameUnexported: job.RenameUnexported,
+				Simplify:         job.Simplify,
+				LineDirectives:   job.LineDirectives,
+				TemplateFile:     job.File,
+				TestFiles:        job.TestFiles,
+				Existing:         existing,
+				Package:          pkgName,
+				OutputFile:       output,
+				Header:           true,
+				Adopt:            job.Adopt,
+			})
+			if err != nil {
+				return nil, me(err, "generate %s from %s", output, job.From)
+			}
+			src, err = result.Source()
+			if err != nil { //NOCOVER
+				return nil, me(err, "format %s", output)
+			}
+			for name, pos := range result.origins() {
+				origins[output][name] = pos
+			}
+			f, err := parser.ParseFile(loader.FileSet, output, src, parser.ParseComments)
+			if err != nil { //NOCOVER
+				return nil, me(err, "parse generated %s", output)
+			}
+			existing = []*ast.File{f}
+		}
+		if len(origins[output]) == 0 && !exists && jobs[output][0].File != "" {
+			// nothing generated from the template file
+			continue
+		}
+		ret[output] = src
+	}
+
+	// outputs in the same package are checked together
+	if verify {
+		if err := verifyOutputs(ret, origins); err != nil {
+			return nil, me(err, "verify")
+		}
+	}
+
+	return ret, nil
+}
+
+// Check executes all jobs and reports a *StaleError if any output file would change
+func (m *Manifest) Check(verify bool) error {
+	outputs, err := m.Generate(verify)
+	if err != nil {
+		return err
+	}
+	return checkFiles(outputs)
+}
+
+// Run executes all jobs and writes output files
+func (m *Manifest) Run(verify bool) error {
+	outputs, err := m.Generate(verify)
+	if err != nil {
+		return err
+	}
+	for path, content := range outputs {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return me(err, "write %s", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// GeneratedMarker marks a declaration as generated from the template package following it.
+// Only marked declarations are replaced on regeneration.
+const GeneratedMarker = "//ccg:generated"
+
+// ConflictError reports template declarations sharing names with hand-written ones
+type ConflictError struct {
+	Names []string
+}
+
+func (e *ConflictError) Error() string {
+	return sp("conflict with declarations not generated by ccg: %s", strings.Join(e.Names, ", "))
+}
+
+// CollisionError reports different declarations, or variables, of the same names generated by other instantiations in the same file
+type CollisionError struct {
+	Names  []string
+	Others []Provenance // instantiations generated the names, parallel to Names
+}
+
+func (e *CollisionError) Error() string {
+	var parts []string
+	for i, name := range e.Names {
+		parts = append(parts, sp("%s generated from %s", name, e.Others[i].From))
+	}
+	return sp("collide with other instantiations: %s", strings.Join(parts, ", "))
+}
+
+// nodeSource returns the formatted source of a declaration or spec, comments excluded
+func nodeSource(fset *token.FileSet, node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		copied := *n
+		copied.Doc = nil
+		node = &copied
+	case *ast.TypeSpec:
+		copied := *n
+		copied.Doc = nil
+		copied.Comment = nil
+		node = &copied
+	case *ast.ValueSpec:
+		copied := *n
+		copied.Doc = nil
+		copied.Comment = nil
+		node = &copied
+	}
+	buf := new(bytes.Buffer)
+	format.Node(buf, fset, node)
+	return buf.String()
+}
+
+// isGenerated reports whether any of the doc comments contains the generated marker
+func isGenerated(docs ...*ast.CommentGroup) bool {
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		for _, comment := range doc.List {
+			if comment.Text == GeneratedMarker || strings.HasPrefix(comment.Text, GeneratedMarker+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declIdents returns identifiers of top-level names declared by decl
+func declIdents(decl ast.Decl) (ret []*ast.Ident) {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		ret = append(ret, decl.Name)
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				ret = append(ret, spec.Name)
+			case *ast.ValueSpec:
+				ret = append(ret, spec.Names...)
+			}
+		}
+	}
+	return
+}
+
+// declNames returns top-level names declared by decl, methods in the form of Type.Method
+func declNames(decl ast.Decl) (ret []string) {
+	if decl, ok := decl.(*ast.FuncDecl); ok {
+		return []string{getFuncDeclName(decl)}
+	}
+	for _, id := range declIdents(decl) {
+		ret = append(ret, id.Name)
+	}
+	return
+}
+
+// markGenerated inserts the generated marker above top-level declarations of names not marked yet
+func markGenerated(src []byte, names StrSet, template string) ([]byte, error) {
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int
+	for _, decl := range f.Decls {
+		var marked bool
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			marked = isGenerated(decl.Doc)
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			marked = isGenerated(decl.Doc)
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					marked = marked || isGenerated(spec.Doc)
+				case *ast.ValueSpec:
+					marked = marked || isGenerated(spec.Doc)
+				}
+			}
+		}
+		if marked {
+			continue
+		}
+		for _, name := range declNames(decl) {
+			if names.In(name) {
+				pos := fset.PositionFor(decl.Pos(), false)
+				offsets = append(offsets, pos.Offset-pos.Column+1)
+				break
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	marker := []byte(GeneratedMarker + " " + template + "\n")
+	for _, offset := range offsets {
+		src = append(src[:offset], append(append([]byte(nil), marker...), src[offset:]...)...)
+	}
+	return src, nil
+}
@@ -0,0 +1,178 @@
+package ccg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	// GeneratedHeader marks files generated by ccg, see https://golang.org/s/generatedcode
+	GeneratedHeader = "// Code generated by ccg. DO NOT EDIT."
+
+	// ProvenanceDirective prefixes the JSON-encoded Provenance of an instantiation in a generated file
+	ProvenanceDirective = "//ccg:provenance"
+)
+
+// Provenance records how a generated file is instantiated from a template package
+type Provenance struct {
+	From    string            `json:"from"`
+	Version string            `json:"version,omitempty"` // module version of the template package
+	Hash    string            `json:"hash,omitempty"`    // hash of the template package sources
+	Params  map[string]string `json:"params,omitempty"`
+	Renames map[string]string `json:"renames,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+	Uses    []string          `json:"uses,omitempty"`
+	Suffix  string            `json:"suffix,omitempty"` // helper suffix
+	Decls   []string          `json:"decls,omitempty"`  // generated declarations
+
+	RenameUnexported bool   `json:"rename_unexported,omitempty"`
+	Simplify         bool   `json:"simplify,omitempty"`
+	LineDirectives   bool   `json:"line_directives,omitempty"`
+	File             string `json:"file,omitempty"` // template file generated, in split output
+	TestFiles        bool   `json:"test_files,omitempty"`
+}
+
+// sameInstantiation reports whether p and p2 are the same instantiation, ignoring template versions, uses, simplification, line directives and results
+func (p Provenance) sameInstantiation(p2 Provenance) bool {
+	return p.From == p2.From &&
+		sameMap(p.Params, p2.Params) &&
+		sameMap(p.Renames, p2.Renames) &&
+		sameMap(p.Args, p2.Args) &&
+		p.Suffix == p2.Suffix &&
+		p.RenameUnexported == p2.RenameUnexported &&
+		p.File == p2.File &&
+		p.TestFiles == p2.TestFiles
+}
+
+// sameMap reports whether m and m2 have the same entries, nil and empty maps are the same as provenances omit empty maps
+func sameMap(m, m2 map[string]string) bool {
+	if len(m) != len(m2) {
+		return false
+	}
+	for k, v := range m {
+		if v2, ok := m2[k]; !ok || v2 != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Job returns the job generating output from p
+func (p Provenance) Job(output string) Job {
+	return Job{
+		From:    p.From,
+		Params:  p.Params,
+		Renames: p.Renames,
+		Args:    p.Args,
+		Uses:    p.Uses,
+		Suffix:  p.Suffix,
+		Output:  output,
+
+		RenameUnexported: p.RenameUnexported,
+		Simplify:         p.Simplify,
+		LineDirectives:   p.LineDirectives,
+		File:             p.File,
+		TestFiles:        p.TestFiles,
+	}
+}
+
+// ReadProvenance returns provenances recorded in files
+func ReadProvenance(files ...*ast.File) ([]Provenance, error) {
+	var ret []Provenance
+	for _, f := range files {
+		for _, group := range f.Comments {
+			for _, comment := range group.List {
+				if !strings.HasPrefix(comment.Text, ProvenanceDirective+" ") {
+					continue
+				}
+				var p Provenance
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(comment.Text, ProvenanceDirective+" ")), &p); err != nil {
+					return nil, me(err, "decode provenance")
+				}
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// hashPackage returns the hash of the package source files
+func hashPackage(pkg *packages.Package) (string, error) {
+	filenames := append([]string(nil), pkg.CompiledGoFiles...)
+	sort.Strings(filenames)
+	h := sha256.New()
+	for _, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		h.Write(content)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// addHeader prepends the generated-code header and provenance comments to src, replacing existing ones
+func addHeader(src []byte, provenances []Provenance) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(GeneratedHeader + "\n")
+	for _, p := range provenances {
+		bs, err := json.Marshal(p)
+		if err != nil { //NOCOVER
+			return nil, err
+		}
+		buf.WriteString(ProvenanceDirective + " ")
+		buf.Write(bs)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(nil, len(src)+1)
+	header := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header {
+			if line == GeneratedHeader || strings.HasPrefix(line, ProvenanceDirective+" ") {
+				continue
+			}
+			if line == "" && buf.Bytes()[buf.Len()-2] == '\n' {
+				continue
+			}
+			if strings.HasPrefix(line, "package ") {
+				header = false
+			}
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// ProvenanceManifest returns a manifest regenerating the file at path from its recorded provenances
+func ProvenanceManifest(path string) (*Manifest, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, me(err, "parse %s", path)
+	}
+	provenances, err := ReadProvenance(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(provenances) == 0 {
+		return nil, me(nil, "no provenance in %s", path)
+	}
+	manifest := new(Manifest)
+	for _, p := range provenances {
+		manifest.Jobs = append(manifest.Jobs, p.Job(path))
+	}
+	return manifest, nil
+}
@@ -0,0 +1,149 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// qualifiedType matches type names qualified by import paths in type expressions, as in github.com/acme/model.*User or time.Time
+var qualifiedType = regexp.MustCompile(`([\w.~-]+(?:/[\w.~-]+)+|[A-Za-z_]\w*)\.(\**)([A-Za-z_]\w*)`)
+
+// qualifier rewrites qualified type names to package-qualified ones, collecting imports to add to the output
+type qualifier struct {
+	dir     string            // directory to resolve import paths in
+	taken   StrSet            // names not available for imports
+	names   map[string]string // import path to package name in the output
+	imports map[string]string // import path to package name, for imports to add
+}
+
+func newQualifier(dir string, pkg *packages.Package, existing []*ast.File) *qualifier {
+	q := &qualifier{
+		dir:     dir,
+		taken:   NewStrSet(),
+		names:   make(map[string]string),
+		imports: make(map[string]string),
+	}
+	for _, name := range pkg.Types.Scope().Names() {
+		q.taken.Add(name)
+	}
+	for importPath, imported := range pkg.Imports {
+		q.taken.Add(imported.Name)
+		q.names[importPath] = imported.Name
+	}
+	for _, f := range existing {
+		for _, decl := range f.Decls {
+			for _, name := range declNames(decl) {
+				q.taken.Add(name)
+			}
+		}
+		for _, spec := range f.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil { //NOCOVER
+				continue
+			}
+			name := path.Base(importPath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			q.taken.Add(name)
+			q.names[importPath] = name
+		}
+	}
+	return q
+}
+
+// qualify rewrites qualified type names in expr, returning a valid type expression.
+// names qualified by package names imported by the template or existing files, or by non-package names without slashes, are kept.
+func (q *qualifier) qualify(expr string) (string, error) {
+	imported := NewStrSet()
+	for _, name := range q.names {
+		imported.Add(name)
+	}
+	var err error
+	ret := qualifiedType.ReplaceAllStringFunc(expr, func(s string) string {
+		match := qualifiedType.FindStringSubmatch(s)
+		importPath := match[1]
+		single := !strings.Contains(importPath, "/")
+		if _, ok := q.names[importPath]; !ok && single && imported.In(importPath) {
+			return s
+		}
+		name, e := q.name(importPath)
+		if e != nil {
+			if !single {
+				err = e
+			}
+			return s
+		}
+		return match[2] + name + "." + match[3]
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := parser.ParseExpr(ret); err != nil {
+		return "", me(err, "invalid type expression %s", expr)
+	}
+	return ret, nil
+}
+
+// name returns the package name of importPath in the output, aliased if the name is taken
+func (q *qualifier) name(importPath string) (string, error) {
+	if name, ok := q.names[importPath]; ok {
+		return name, nil
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName,
+		Dir:  q.dir,
+	}, importPath)
+	if err != nil {
+		return "", me(err, "load package %s", importPath)
+	}
+	if len(pkgs) != 1 || len(pkgs[0].Errors) > 0 || pkgs[0].Name == "" {
+		return "", me(nil, "package %s not found", importPath)
+	}
+	name := pkgs[0].Name
+	alias := name
+	for i := 2; q.taken.In(alias); i++ {
+		alias = sp("%s%d", name, i)
+	}
+	q.taken.Add(alias)
+	q.names[importPath] = alias
+	q.imports[importPath] = alias
+	return alias, nil
+}
+
+// addImports adds imports of paths to names to the source file
+func addImports(src []byte, imports map[string]string) ([]byte, error) {
+	fset := new(token.FileSet)
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for importPath := range imports {
+		paths = append(paths, importPath)
+	}
+	sort.Strings(paths)
+	for _, importPath := range paths {
+		name := imports[importPath]
+		if name == path.Base(importPath) {
+			name = ""
+		}
+		astutil.AddNamedImport(fset, f, name, importPath)
+	}
+	buf := new(bytes.Buffer)
+	if err := format.Node(buf, fset, f); err != nil { //NOCOVER
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,69 @@
+package ccg
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// RenameWildcard stands for the original name in rename patterns, as in *=Int*
+const RenameWildcard = "*"
+
+// isRenamePattern reports whether a rename key is a pattern instead of a name
+func isRenamePattern(from string) bool {
+	return strings.Contains(from, RenameWildcard)
+}
+
+// matchRenamePattern returns the part of name matched by the wildcard in pattern
+func matchRenamePattern(pattern, name string) (string, bool) {
+	i := strings.Index(pattern, RenameWildcard)
+	prefix, suffix := pattern[:i], pattern[i+len(RenameWildcard):]
+	if len(name) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// expandRenames applies rename patterns to names, returning renames of names only.
+// explicit renames override patterns, and the most specific pattern wins if several match.
+// patterns apply to exported names, and to unexported ones if unexported is true.
+func expandRenames(renames map[string]string, names []string, skip StrSet, unexported bool) (map[string]string, error) {
+	ret := make(map[string]string)
+	var patterns []string
+	for from, to := range renames {
+		if !isRenamePattern(from) {
+			ret[from] = to
+			continue
+		}
+		if strings.Count(from, RenameWildcard) != 1 || strings.Count(to, RenameWildcard) != 1 {
+			return nil, me(nil, "invalid rename pattern %s=%s", from, to)
+		}
+		patterns = append(patterns, from)
+	}
+	if len(patterns) == 0 {
+		return ret, nil
+	}
+	for _, name := range names {
+		if name == "_" || skip.In(name) || (!unexported && !ast.IsExported(name)) {
+			continue
+		}
+		if _, ok := ret[name]; ok {
+			continue
+		}
+		var best, match string
+		for _, pattern := range patterns {
+			m, ok := matchRenamePattern(pattern, name)
+			if !ok {
+				continue
+			}
+			if best == "" || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+				best = pattern
+				match = m
+			}
+		}
+		if best != "" {
+			ret[name] = strings.Replace(renames[best], RenameWildcard, match, 1)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,104 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Result is the output of an instantiation
+type Result struct {
+	File    *ast.File
+	FileSet *token.FileSet
+	Decls   []GeneratedDecl // declarations generated from the template package, in the output order
+	Imports []string        // import paths added to the output, not imported by existing files
+
+	declsOnly     bool                        // no package specified, only declarations are written
+	strippedLines int                         // lines of the package clause stripped from the source if declsOnly
+	stmts         map[string][]token.Position // template positions of statements of generated functions
+	module        *packages.Module            // of the template package, nil if not in a module
+}
+
+// GeneratedDecl is a declaration generated from the template package
+type GeneratedDecl struct {
+	Name     string         // name in the output, methods in the form of Type.Method
+	Template token.Position // position in the template package
+}
+
+func newResult(
+	src []byte,
+	filename string,
+	declsOnly bool,
+	generated StrSet,
+	origins map[string]token.Position,
+	stmts map[string][]token.Position,
+	existing []*ast.File,
+	module *packages.Module,
+) (*Result, error) {
+	result := &Result{
+		FileSet:   token.NewFileSet(), // based at 1, so the package clause at offset 0 has a valid position
+		declsOnly: declsOnly,
+		stmts:     stmts,
+		module:    module,
+	}
+	var err error
+	result.File, err = parser.ParseFile(result.FileSet, filename, src, parser.ParseComments)
+	if err != nil { //NOCOVER
+		return nil, me(err, "parse output")
+	}
+	if declsOnly {
+		rest := bytes.TrimLeft(src[result.FileSet.Position(result.File.Name.End()).Offset:], "\n")
+		result.strippedLines = bytes.Count(src[:len(src)-len(rest)], []byte("\n"))
+	}
+	for _, decl := range result.File.Decls {
+		for _, name := range declNames(decl) {
+			if generated.In(name) {
+				result.Decls = append(result.Decls, GeneratedDecl{
+					Name:     name,
+					Template: origins[name],
+				})
+			}
+		}
+	}
+	imported := NewStrSet()
+	for _, f := range existing {
+		for _, spec := range f.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			imported.Add(path)
+		}
+	}
+	for _, spec := range result.File.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if !imported.In(path) {
+			result.Imports = append(result.Imports, path)
+		}
+	}
+	return result, nil
+}
+
+// Source returns the formatted source of the output
+func (r *Result) Source() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := format.Node(buf, r.FileSet, r.File); err != nil {
+		return nil, err
+	}
+	bs := buf.Bytes()
+	if r.declsOnly { // strip the package clause
+		bs = bytes.TrimLeft(bs[r.FileSet.Position(r.File.Name.End()).Offset:], "\n")
+	}
+	return bs, nil
+}
+
+// origins returns template positions of generated declarations by name
+func (r *Result) origins() map[string]token.Position {
+	ret := make(map[string]token.Position)
+	for _, decl := range r.Decls {
+		ret[decl.Name] = decl.Template
+	}
+	return ret
+}
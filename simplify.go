@@ -0,0 +1,526 @@
+package ccg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// simplify prunes dead branches of if statements with constant conditions,
+// and of type switches on operands of statically known types.
+// src is type-checked with other files of the package of outputFile if any, imports of src are resolved in dir.
+func simplify(src []byte, dir string, outputFile string) ([]byte, error) {
+	fset := new(token.FileSet)
+	f, info := checkInPackage(fset, src, outputFile)
+	if f == nil {
+		var err error
+		f, info, err = checkFile(fset, src, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	usedImports := func() StrSet {
+		ret := NewStrSet()
+		ast.Inspect(f, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok {
+				if name, ok := info.Uses[id].(*types.PkgName); ok {
+					ret.Add(name.Imported().Path())
+				}
+			}
+			return true
+		})
+		return ret
+	}
+	usedBefore := usedImports()
+
+	var removed []ast.Node
+	synthesized := NewStrSet()         // imports used by synthesized statements
+	spliced := make(map[ast.Node]bool) // statement lists with spliced statements
+	remove := func(node ast.Node) {
+		if node != nil && node.Pos().IsValid() {
+			removed = append(removed, node)
+		}
+	}
+	astutil.Apply(f, nil, func(cursor *astutil.Cursor) bool {
+		if _, ok := cursor.Parent().(*ast.LabeledStmt); ok {
+			return true
+		}
+		switch node := cursor.Node().(type) {
+
+		case *ast.IfStmt:
+			tv := info.Types[node.Cond]
+			if tv.Value == nil || tv.Value.Kind() != constant.Bool {
+				return true
+			}
+			var stmts []ast.Stmt
+			if node.Init != nil {
+				stmts = append(stmts, node.Init)
+			}
+			taken := node.Else
+			if constant.BoolVal(tv.Value) {
+				taken = node.Body
+				remove(node.Else)
+			} else {
+				remove(node.Body)
+			}
+			switch taken := taken.(type) {
+			case *ast.BlockStmt:
+				stmts = append(stmts, taken.List...)
+			case *ast.IfStmt:
+				stmts = append(stmts, taken)
+			}
+			if replaceStmt(cursor, stmts, node.Init != nil) {
+				spliced[cursor.Parent()] = true
+			}
+
+		case *ast.TypeSwitchStmt:
+			var symbol *ast.Ident
+			var assert *ast.TypeAssertExpr
+			switch stmt := node.Assign.(type) {
+			case *ast.ExprStmt:
+				assert = stmt.X.(*ast.TypeAssertExpr)
+			case *ast.AssignStmt:
+				symbol = stmt.Lhs[0].(*ast.Ident)
+				assert = stmt.Rhs[0].(*ast.TypeAssertExpr)
+			}
+			// only conversions of side-effect free operands to interfaces, as in any(x)
+			conversion, ok := assert.X.(*ast.CallExpr)
+			if !ok || len(conversion.Args) != 1 || !info.Types[conversion.Fun].IsType() {
+				return true
+			}
+			operand := conversion.Args[0]
+			switch operand.(type) {
+			case *ast.Ident, *ast.SelectorExpr, *ast.BasicLit:
+			default:
+				return true
+			}
+			t := info.TypeOf(operand)
+			if !isValidType(t) || types.IsInterface(t) {
+				return true
+			}
+			t = types.Default(t)
+
+			// the first matching clause
+			var matched, defaultClause *ast.CaseClause
+			var caseType ast.Expr
+			for _, stmt := range node.Body.List {
+				clause := stmt.(*ast.CaseClause)
+				if clause.List == nil {
+					defaultClause = clause
+					continue
+				}
+				for _, expr := range clause.List {
+					tv, ok := info.Types[expr]
+					if !ok {
+						return true // unknown type
+					}
+					if tv.IsNil() {
+						continue
+					}
+					if !isValidType(tv.Type) {
+						return true
+					}
+					if types.Identical(t, tv.Type) ||
+						(types.IsInterface(tv.Type) && types.Implements(t, tv.Type.Underlying().(*types.Interface))) {
+						matched = clause
+						if len(clause.List) == 1 {
+							caseType = expr
+						}
+						break
+					}
+				}
+				if matched != nil {
+					break
+				}
+			}
+			if matched == nil {
+				matched = defaultClause
+			}
+
+			var stmts []ast.Stmt
+			scoped := false
+			if node.Init != nil {
+				stmts = append(stmts, node.Init)
+				scoped = true
+			}
+			if matched != nil {
+				if hasBreak(matched.Body) {
+					return true
+				}
+				if symbol != nil && usesObject(info, matched.Body, info.Implicits[matched]) {
+					value := ast.Expr(conversion)
+					if caseType != nil && types.Identical(t, info.TypeOf(caseType)) {
+						value = operand
+					} else if caseType != nil {
+						value = &ast.CallExpr{
+							Fun:  caseType,
+							Args: []ast.Expr{operand},
+						}
+					}
+					// imports referenced by the value, which is formatted as an identifier
+					ast.Inspect(value, func(node ast.Node) bool {
+						if id, ok := node.(*ast.Ident); ok {
+							if name, ok := info.Uses[id].(*types.PkgName); ok {
+								synthesized.Add(name.Imported().Path())
+							}
+						}
+						return true
+					})
+					// positioned at the clause to keep line breaks
+					valueSrc := new(bytes.Buffer)
+					if err := format.Node(valueSrc, fset, value); err != nil { //NOCOVER
+						return true
+					}
+					stmts = append(stmts, &ast.AssignStmt{
+						Lhs: []ast.Expr{&ast.Ident{
+							Name:    symbol.Name,
+							NamePos: matched.Case,
+						}},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{&ast.Ident{
+							Name:    valueSrc.String(),
+							NamePos: matched.Case,
+						}},
+					})
+					scoped = true
+				}
+				stmts = append(stmts, matched.Body...)
+			}
+			for _, stmt := range node.Body.List {
+				if stmt != matched {
+					remove(stmt)
+				}
+			}
+			if replaceStmt(cursor, stmts, scoped) {
+				spliced[cursor.Parent()] = true
+			}
+
+		}
+		return true
+	})
+
+	// drop unreachable statements following spliced terminating statements
+	for node := range spliced {
+		var list *[]ast.Stmt
+		switch node := node.(type) {
+		case *ast.BlockStmt:
+			list = &node.List
+		case *ast.CaseClause:
+			list = &node.Body
+		case *ast.CommClause:
+			list = &node.Body
+		default:
+			continue
+		}
+		var stmts []ast.Stmt
+		unreachable := false
+		for _, stmt := range *list {
+			if _, ok := stmt.(*ast.LabeledStmt); ok {
+				unreachable = false
+			}
+			if unreachable {
+				remove(stmt)
+				continue
+			}
+			stmts = append(stmts, stmt)
+			unreachable = isTerminating(stmt)
+		}
+		*list = stmts
+	}
+
+	// remove imports used in pruned branches only
+	usedAfter := usedImports()
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		var specs []ast.Spec
+		for _, spec := range decl.Specs {
+			path, _ := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+			if usedBefore.In(path) && !usedAfter.In(path) && !synthesized.In(path) {
+				remove(spec)
+				continue
+			}
+			specs = append(specs, spec)
+		}
+		if len(specs) == 1 && len(decl.Specs) > 1 {
+			decl.Lparen = token.NoPos
+			decl.Rparen = token.NoPos
+		}
+		decl.Specs = specs
+	}
+	f.Decls = filterDecls(f.Decls, func(interface{}) bool {
+		return true
+	})
+
+	// drop comments in pruned branches
+	var comments []*ast.CommentGroup
+	for _, group := range f.Comments {
+		keep := true
+		for _, node := range removed {
+			if group.Pos() >= node.Pos() && group.End() <= node.End() {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			comments = append(comments, group)
+		}
+	}
+	f.Comments = comments
+
+	buf := new(bytes.Buffer)
+	if err := format.Node(buf, fset, f); err != nil { //NOCOVER
+		return nil, err
+	}
+
+	// remove blank lines left by pruned lines at the beginning and end of blocks
+	lines := bytes.Split(buf.Bytes(), []byte("\n"))
+	var kept [][]byte
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 && i > 0 && i+1 < len(lines) &&
+			(bytes.HasSuffix(lines[i-1], []byte("{")) || bytes.Equal(bytes.TrimSpace(lines[i+1]), []byte("}"))) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return format.Source(bytes.Join(kept, []byte("\n")))
+}
+
+// replaceStmt replaces the statement at cursor with stmts, in a block if scoped or declaring names.
+// it reports whether stmts are spliced into the statement list containing cursor.
+func replaceStmt(cursor *astutil.Cursor, stmts []ast.Stmt, scoped bool) bool {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.DeclStmt, *ast.LabeledStmt:
+			scoped = true
+		case *ast.AssignStmt:
+			if stmt.Tok == token.DEFINE {
+				scoped = true
+			}
+		}
+	}
+	switch {
+	case cursor.Index() < 0: // not in a statement list, as the else branch
+		if len(stmts) == 1 && !scoped {
+			if stmt, ok := stmts[0].(*ast.IfStmt); ok {
+				cursor.Replace(stmt)
+				return false
+			}
+		}
+		cursor.Replace(&ast.BlockStmt{
+			List: stmts,
+		})
+		return false
+	case scoped:
+		cursor.Replace(&ast.BlockStmt{
+			List: stmts,
+		})
+	default:
+		for _, stmt := range stmts {
+			cursor.InsertBefore(stmt)
+		}
+		cursor.Delete()
+	}
+	return true
+}
+
+// isTerminating reports whether stmt is a return, goto or panic, or a block ending with one
+func isTerminating(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return stmt.Tok == token.GOTO
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		id, ok := call.Fun.(*ast.Ident)
+		return ok && id.Name == "panic"
+	case *ast.BlockStmt:
+		return len(stmt.List) > 0 && isTerminating(stmt.List[len(stmt.List)-1])
+	}
+	return false
+}
+
+// hasBreak reports whether stmts contain unlabeled break statements of the enclosing statement
+func hasBreak(stmts []ast.Stmt) bool {
+	ret := false
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt, *ast.FuncLit:
+				return false
+			case *ast.BranchStmt:
+				if node.Tok == token.BREAK && node.Label == nil {
+					ret = true
+				}
+			}
+			return !ret
+		})
+	}
+	return ret
+}
+
+// usesObject reports whether stmts reference obj
+func usesObject(info *types.Info, stmts []ast.Stmt, obj types.Object) bool {
+	ret := false
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok && obj != nil && info.Uses[id] == obj {
+				ret = true
+			}
+			return !ret
+		})
+	}
+	return ret
+}
+
+// checkInPackage type-checks src as outputFile with other files of its package, returning nil if the package is not loadable
+func checkInPackage(fset *token.FileSet, src []byte, outputFile string) (*ast.File, *types.Info) {
+	if outputFile == "" {
+		return nil, nil
+	}
+	path, err := filepath.Abs(outputFile)
+	if err != nil { //NOCOVER
+		return nil, nil
+	}
+	tests := strings.HasSuffix(path, "_test.go")
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports |
+			packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Dir:  filepath.Dir(path),
+		Fset: fset,
+		Overlay: map[string][]byte{
+			path: src,
+		},
+		Tests: tests,
+	}, ".")
+	if err != nil {
+		return nil, nil
+	}
+	if tests {
+		pkgs = testVariants(pkgs)
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Syntax {
+			if fset.Position(f.Package).Filename == path && pkg.TypesInfo != nil {
+				return f, pkg.TypesInfo
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkFile type-checks src alone, names declared in other files of the destination package are left undefined
+func checkFile(fset *token.FileSet, src []byte, dir string) (*ast.File, *types.Info, error) {
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil { //NOCOVER
+			return nil, nil, err
+		}
+		if path != "unsafe" {
+			paths = append(paths, path)
+		}
+	}
+	imported := map[string]*types.Package{
+		"unsafe": types.Unsafe,
+	}
+	if len(paths) > 0 {
+		pkgs, err := packages.Load(&packages.Config{
+			Mode: packages.NeedName | packages.NeedTypes | packages.NeedImports,
+			Dir:  dir,
+		}, paths...)
+		if err != nil {
+			return nil, nil, me(err, "load imports")
+		}
+		for _, pkg := range pkgs {
+			if pkg.Types != nil {
+				imported[pkg.PkgPath] = pkg.Types
+			}
+		}
+	}
+	info := &types.Info{
+		Types:     make(map[ast.Expr]types.TypeAndValue),
+		Defs:      make(map[*ast.Ident]types.Object),
+		Uses:      make(map[*ast.Ident]types.Object),
+		Implicits: make(map[ast.Node]types.Object),
+	}
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if pkg, ok := imported[path]; ok {
+				return pkg, nil
+			}
+			return nil, fmt.Errorf("package %s not found", path)
+		}),
+		Sizes: types.SizesFor("gc", runtime.GOARCH),
+		Error: func(error) {},
+	}
+	conf.Check(f.Name.Name, fset, []*ast.File{f}, info)
+	return f, info, nil
+}
+
+// isValidType reports whether t is resolved, with no invalid types in its composition
+func isValidType(t types.Type) bool {
+	switch t := t.(type) {
+	case nil:
+		return false
+	case *types.Basic:
+		return t.Kind() != types.Invalid
+	case *types.Pointer:
+		return isValidType(t.Elem())
+	case *types.Slice:
+		return isValidType(t.Elem())
+	case *types.Array:
+		return isValidType(t.Elem())
+	case *types.Chan:
+		return isValidType(t.Elem())
+	case *types.Map:
+		return isValidType(t.Key()) && isValidType(t.Elem())
+	case *types.Named:
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if !isValidType(t.TypeArgs().At(i)) {
+				return false
+			}
+		}
+		return isValidType(t.Underlying())
+	case *types.Alias:
+		return isValidType(types.Unalias(t))
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if !isValidType(t.Field(i).Type()) {
+				return false
+			}
+		}
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if !isValidType(tuple.At(i).Type()) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package ccg
+
+import (
+	"go/ast"
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SourceMap maps lines of generated declarations and statements in the output to the template package
+type SourceMap struct {
+	File     string        `json:"file"`
+	Mappings []LineMapping `json:"mappings"` // in line order
+}
+
+// LineMapping maps a line of the output to a line in the template package
+type LineMapping struct {
+	Line         int    `json:"line"`
+	TemplateFile string `json:"template_file"` // relative to the directory of the output file if any, or path@version/file for other modules
+	TemplateLine int    `json:"template_line"`
+}
+
+// lineDirective matches //line directives, capturing the file name
+var lineDirective = regexp.MustCompile(`^//line (.+?):\d+(?::\d+)?$`)
+
+// declMapping is the source map of a generated declaration
+type declMapping struct {
+	start, end int // lines spanned in the output, including doc comments
+	lines      []LineMapping
+}
+
+// stmtPositions returns positions of statements in the body of decl, in syntax order
+func stmtPositions(decl ast.Decl) []token.Pos {
+	fn, ok := decl.(*ast.FuncDecl)
+	if !ok || fn.Body == nil {
+		return nil
+	}
+	var ret []token.Pos
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		if stmt, ok := node.(ast.Stmt); ok && node != fn.Body {
+			ret = append(ret, stmt.Pos())
+		}
+		return true
+	})
+	return ret
+}
+
+// templateFile returns the file name of pos relative to the directory of the output file if any.
+// files of a template module not containing the output file are named as path@version/file, independent of where the module is stored
+func (r *Result) templateFile(pos token.Position) string {
+	filename := r.FileSet.File(r.File.Pos()).Name()
+	if filename == "" {
+		return pos.Filename
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil { //NOCOVER
+		return pos.Filename
+	}
+	if m := r.module; m != nil && m.Dir != "" && !inDir(m.Dir, dir) && inDir(m.Dir, pos.Filename) {
+		rel, _ := filepath.Rel(m.Dir, pos.Filename)
+		path := m.Path
+		if m.Version != "" {
+			path += "@" + m.Version
+		}
+		return path + "/" + filepath.ToSlash(rel)
+	}
+	rel, err := filepath.Rel(dir, pos.Filename)
+	if err != nil {
+		return pos.Filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+// inDir reports whether path is dir or in dir
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// mappings returns source maps of generated declarations, by lines of the output file before stripping.
+// statements are mapped if the function body has the same statements as the template one.
+func (r *Result) mappings() []declMapping {
+	line := func(pos token.Pos) int {
+		return r.FileSet.PositionFor(pos, false).Line
+	}
+	origins := r.origins()
+	var ret []declMapping
+	for _, decl := range r.File.Decls {
+		nodes := declNodes(decl)
+		var names []string
+		for name := range nodes {
+			if _, ok := origins[name]; ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return nodes[names[i]].Pos() < nodes[names[j]].Pos()
+		})
+		m := declMapping{
+			start: line(decl.Pos()),
+			end:   line(decl.End()),
+		}
+		var doc *ast.CommentGroup
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			doc = decl.Doc
+		case *ast.GenDecl:
+			doc = decl.Doc
+		}
+		if doc != nil {
+			m.start = line(doc.Pos())
+		}
+		mapped := make(map[int]bool)
+		add := func(pos token.Pos, template token.Position) {
+			if !template.IsValid() || mapped[line(pos)] {
+				return
+			}
+			mapped[line(pos)] = true
+			m.lines = append(m.lines, LineMapping{
+				Line:         line(pos),
+				TemplateFile: r.templateFile(template),
+				TemplateLine: template.Line,
+			})
+		}
+		for _, name := range names {
+			add(nodes[name].Pos(), origins[name])
+			stmts := stmtPositions(decl)
+			if templates := r.stmts[name]; len(templates) == len(stmts) {
+				for i, pos := range stmts {
+					add(pos, templates[i])
+				}
+			}
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// SourceMap returns the mapping from lines of generated declarations and statements in the output to the template package.
+// statements of functions changed by simplification are not mapped.
+func (r *Result) SourceMap() *SourceMap {
+	m := &SourceMap{
+		File:     r.FileSet.File(r.File.Pos()).Name(),
+		Mappings: []LineMapping{},
+	}
+	for _, decl := range r.mappings() {
+		for _, mapping := range decl.lines {
+			mapping.Line -= r.strippedLines
+			m.Mappings = append(m.Mappings, mapping)
+		}
+	}
+	sort.Slice(m.Mappings, func(i, j int) bool {
+		return m.Mappings[i].Line < m.Mappings[j].Line
+	})
+	return m
+}
+
+// lineDirectives returns src, the source of r, with //line directives mapping generated declarations and statements to the template package if add is true.
+// previous directives of generated declarations are removed, and declarations with directives are followed by ones restoring positions of the output file.
+func (r *Result) lineDirectives(src []byte, add bool) ([]byte, error) {
+	self := filepath.Base(r.FileSet.File(r.File.Pos()).Name())
+	isRestore := func(line string) bool {
+		match := lineDirective.FindStringSubmatch(line)
+		return match != nil && match[1] == self
+	}
+	lines := strings.Split(string(src), "\n")
+	isDirective := func(n int) bool {
+		return lineDirective.MatchString(lines[n-1])
+	}
+
+	drop := make(map[int]bool)
+	insert := make(map[int]string)
+	for i, line := range lines {
+		// restoring directives are regenerated
+		if isRestore(line) {
+			drop[i+1] = true
+		}
+	}
+	for _, decl := range r.mappings() {
+		for n := decl.start; n <= decl.end; n++ {
+			if isDirective(n) {
+				drop[n] = true
+			}
+		}
+		if !add {
+			continue
+		}
+		for _, mapping := range decl.lines {
+			insert[mapping.Line] = sp("//line %s:%d", mapping.TemplateFile, mapping.TemplateLine)
+		}
+	}
+
+	restore := make(map[int]bool)
+	for i, decl := range r.File.Decls {
+		if i == len(r.File.Decls)-1 { // nothing to restore
+			break
+		}
+		start := r.FileSet.PositionFor(decl.Pos(), false).Line
+		end := r.FileSet.PositionFor(decl.End(), false).Line
+		for n := start; n <= end; n++ {
+			if _, ok := insert[n]; ok || isDirective(n) && !drop[n] {
+				restore[end] = true
+				break
+			}
+		}
+	}
+
+	var out []string
+	for i, line := range lines {
+		n := i + 1
+		if directive, ok := insert[n]; ok {
+			out = append(out, directive)
+		}
+		if !drop[n] {
+			out = append(out, line)
+		}
+		if restore[n] {
+			out = append(out, "//line "+self+":1") // numbered after formatting
+		}
+	}
+	bs, err := format.Source([]byte(strings.Join(out, "\n")))
+	if err != nil { //NOCOVER
+		return nil, err
+	}
+
+	// formatting may move lines, restoring directives are numbered at last
+	lines = strings.Split(string(bs), "\n")
+	for i, line := range lines {
+		if isRestore(line) {
+			lines[i] = sp("//line %s:%d", self, i+2)
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
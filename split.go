@@ -0,0 +1,69 @@
+package ccg
+
+import (
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// fileName returns the base name of the file f
+func fileName(fset *token.FileSet, f *ast.File) string {
+	return filepath.Base(fset.PositionFor(f.Package, false).Filename)
+}
+
+// mainFile returns the base name of the template file named after the package, or the first file in name order
+func mainFile(fset *token.FileSet, pkg *packages.Package) string {
+	var names []string
+	for _, f := range pkg.Syntax {
+		name := fileName(fset, f)
+		if name == pkg.Name+".go" {
+			return name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 { //NOCOVER
+		return ""
+	}
+	return names[0]
+}
+
+// splitOutput returns the output file of the template file in split mode.
+// the main file is generated to output, others to output with the file name appended, as set_intset_iter.go for iter.go or set_iter.go of package set.
+func splitOutput(output string, pkg *packages.Package, main string, file string) string {
+	if file == main {
+		return output
+	}
+	return strings.TrimSuffix(output, ".go") + "_" + strings.TrimPrefix(file, pkg.Name+"_")
+}
+
+// fileComments returns comments of f before the package clause except the package doc, such as build constraints and license headers
+func fileComments(f *ast.File) []byte {
+	var groups []string
+	for _, group := range f.Comments {
+		if group.End() >= f.Package {
+			break
+		}
+		if group == f.Doc {
+			continue
+		}
+		var lines []string
+		for _, comment := range group.List {
+			lines = append(lines, comment.Text)
+		}
+		groups = append(groups, strings.Join(lines, "\n"))
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(groups, "\n\n") + "\n\n")
+}
+
+// testOutput returns the test file of output, as set_intset_test.go for set_intset.go
+func testOutput(output string) string {
+	return strings.TrimSuffix(output, ".go") + "_test.go"
+}
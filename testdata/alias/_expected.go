@@ -0,0 +1,14 @@
+package foo
+
+type Vec = []int
+
+func Sum(v Vec) (ret int) {
+	for _, e := range v {
+		ret += e
+	}
+	return
+}
+
+func Ints() Vec {
+	return Vec{1, 2, 3}
+}
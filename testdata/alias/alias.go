@@ -0,0 +1,14 @@
+package alias
+
+type Vec[T any] = []T
+
+func Sum[T int | float64](v Vec[T]) (ret T) {
+	for _, e := range v {
+		ret += e
+	}
+	return
+}
+
+func Ints() Vec[int] {
+	return Vec[int]{1, 2, 3}
+}
@@ -0,0 +1,9 @@
+package check
+
+type Ints []int
+
+func NewInts() (ret Ints) {
+	return
+}
+
+const foo = 42
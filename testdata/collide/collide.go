@@ -0,0 +1,16 @@
+package collide
+
+type T interface{}
+
+type Set map[T]struct{}
+
+func (s Set) Add(v T) {
+	s[v] = struct{}{}
+	count++
+}
+
+var count int
+
+func less(a, b T) bool {
+	return false
+}
@@ -1,6 +1,6 @@
 package foo
 
-//Baz
+// Baz
 func Baz() {
 	//baz
 }
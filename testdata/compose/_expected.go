@@ -0,0 +1,49 @@
+package foo
+
+import (
+	"slices"
+)
+
+type StrIntMap struct {
+	keys    []string
+	set     KeySet
+	values  map[string]int
+	counter *KeyCounter
+}
+
+func (m *StrIntMap) Set(k string, v int) {
+	if m.set.Len() == 0 || !m.set.Has(k) {
+		m.set.Add(k)
+		m.keys = append(m.keys, k)
+		slices.Sort(m.keys)
+	}
+	m.values[k] = v
+	m.counter.Add(k)
+}
+
+type KeyCounter struct {
+	seen KeySet
+	n    int
+}
+
+func (c *KeyCounter) Add(v string) {
+	if !c.seen.Has(v) {
+		c.seen.Add(v)
+		c.n++
+	}
+}
+
+type KeySet map[string]struct{}
+
+func (s KeySet) Add(v string) {
+	s[v] = struct{}{}
+}
+
+func (s KeySet) Has(v string) bool {
+	_, ok := s[v]
+	return ok
+}
+
+func (s KeySet) Len() int {
+	return len(s)
+}
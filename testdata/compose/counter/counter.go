@@ -0,0 +1,17 @@
+package counter
+
+import (
+	"github.com/reusee/ccg/testdata/compose/set" //ccg:template Set=KeySet
+)
+
+type Counter[T comparable] struct {
+	seen set.Set[T]
+	n    int
+}
+
+func (c *Counter[T]) Add(v T) {
+	if !c.seen.Has(v) {
+		c.seen.Add(v)
+		c.n++
+	}
+}
@@ -0,0 +1,26 @@
+package sortedmap
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/reusee/ccg/testdata/compose/counter" //ccg:template Counter=KeyCounter
+	"github.com/reusee/ccg/testdata/compose/set"     //ccg:template Set=KeySet
+)
+
+type Map[K cmp.Ordered, V any] struct {
+	keys    []K
+	set     set.Set[K]
+	values  map[K]V
+	counter *counter.Counter[K]
+}
+
+func (m *Map[K, V]) Set(k K, v V) {
+	if m.set.Len() == 0 || !m.set.Has(k) {
+		m.set.Add(k)
+		m.keys = append(m.keys, k)
+		slices.Sort(m.keys)
+	}
+	m.values[k] = v
+	m.counter.Add(k)
+}
@@ -0,0 +1,12 @@
+package foo
+
+type Ts []Int
+
+func (s Ts) Min() (ret Int) {
+	for i, e := range s {
+		if i == 0 || e.Less(ret) {
+			ret = e
+		}
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package constraint
+
+type T interface {
+	Less(T) bool
+}
+
+type K interface{}
+
+type Int int
+
+func (i Int) Less(j Int) bool {
+	return i < j
+}
+
+type Ts []T
+
+func (s Ts) Min() (ret T) {
+	for i, e := range s {
+		if i == 0 || e.Less(ret) {
+			ret = e
+		}
+	}
+	return
+}
+
+type Set map[K]struct{}
+
+type V interface {
+	Key() K
+}
+
+func KeyOf(v V) K {
+	return v.Key()
+}
+
+type S struct{}
+
+func (s S) Key() string {
+	return ""
+}
+
+type E interface{}
+
+func IsNil(e E) bool {
+	return e == nil
+}
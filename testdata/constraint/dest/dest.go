@@ -0,0 +1,13 @@
+package dest
+
+type Good struct{}
+
+func (g Good) Key() string {
+	return ""
+}
+
+type Bad struct{}
+
+func (b Bad) Key() int {
+	return 0
+}
@@ -0,0 +1,12 @@
+package foo
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type Ints []int
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+func NewInts() (ret Ints) {
+	return
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+const foo = 42
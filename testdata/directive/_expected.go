@@ -0,0 +1,54 @@
+package directive
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type Ints []int
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+func NewInts() (ret Ints) {
+	return
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+const foo = 42
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+type IntStrPair struct {
+	first  int
+	second string
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+func NewIntStrPair(first int, second string) IntStrPair {
+	return IntStrPair{first, second}
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+func (p IntStrPair) First() int {
+	return p.first
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+func (p *IntStrPair) Second() string {
+	return p.second
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+func (p IntStrPair) Clone() IntStrPair {
+	return NewIntStrPair(p.First(), p.Second())
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/generic
+func Map[T any, R any](s []T, fn func(T) R) (ret []R) {
+	for _, e := range s {
+		ret = append(ret, fn(e))
+	}
+	return
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type StrTs []map[string]int
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+func StrFoo() (ret StrTs) {
+	return
+}
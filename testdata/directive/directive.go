@@ -0,0 +1,7 @@
+package directive
+
+//ccg:instantiate github.com/reusee/ccg/testdata/copy T=int Ts=Ints Foo=NewInts
+//ccg:instantiate ../generic T1=int T2=string Pair=IntStrPair New=NewIntStrPair
+//ccg:instantiate ../copy T="map[string] int" *=Str*
+
+var _ = NewInts
@@ -0,0 +1,23 @@
+package foo
+
+import "strings"
+
+func Max(a, b string) string {
+	if strings.Compare(a, b) < 0 {
+		return b
+	}
+	return a
+}
+
+func Index(s []string, v string) int {
+	for i, e := range s {
+		if strings.EqualFold(e, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func Join(s []string) string {
+	return strings.Join(s, ",")
+}
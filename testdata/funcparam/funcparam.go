@@ -0,0 +1,31 @@
+package funcparam
+
+import "strings"
+
+type T interface{}
+
+func compare(a, b T) int {
+	panic("placeholder")
+}
+
+var equal func(a, b T) bool
+
+func Max(a, b T) T {
+	if compare(a, b) < 0 {
+		return b
+	}
+	return a
+}
+
+func Index(s []T, v T) int {
+	for i, e := range s {
+		if equal(e, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func Join(s []string) string {
+	return strings.Join(s, ",")
+}
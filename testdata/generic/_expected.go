@@ -0,0 +1,29 @@
+package foo
+
+type IntStrPair struct {
+	first  int
+	second string
+}
+
+func NewIntStrPair(first int, second string) IntStrPair {
+	return IntStrPair{first, second}
+}
+
+func (p IntStrPair) First() int {
+	return p.first
+}
+
+func (p *IntStrPair) Second() string {
+	return p.second
+}
+
+func (p IntStrPair) Clone() IntStrPair {
+	return NewIntStrPair(p.First(), p.Second())
+}
+
+func Map[T any, R any](s []T, fn func(T) R) (ret []R) {
+	for _, e := range s {
+		ret = append(ret, fn(e))
+	}
+	return
+}
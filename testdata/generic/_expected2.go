@@ -0,0 +1,22 @@
+package foo
+
+type Pair struct {
+	first  int
+	second string
+}
+
+func New(first int, second string) Pair {
+	return Pair{first, second}
+}
+
+func (p Pair) First() int {
+	return p.first
+}
+
+func (p *Pair) Second() string {
+	return p.second
+}
+
+func (p Pair) Clone() Pair {
+	return New(p.First(), p.Second())
+}
@@ -0,0 +1,29 @@
+package generic
+
+type Pair[T1, T2 any] struct {
+	first  T1
+	second T2
+}
+
+func New[T1, T2 any](first T1, second T2) Pair[T1, T2] {
+	return Pair[T1, T2]{first, second}
+}
+
+func (p Pair[A, B]) First() A {
+	return p.first
+}
+
+func (p *Pair[T1, T2]) Second() T2 {
+	return p.second
+}
+
+func (p Pair[T1, T2]) Clone() Pair[T1, T2] {
+	return New(p.First(), p.Second())
+}
+
+func Map[T any, R any](s []T, fn func(T) R) (ret []R) {
+	for _, e := range s {
+		ret = append(ret, fn(e))
+	}
+	return
+}
@@ -0,0 +1,10 @@
+package generic2
+
+type Pair[T1, T2 any] struct {
+	first  T1
+	second T2
+}
+
+func (p Pair[T1, T2]) Swap() Pair[T2, T1] {
+	return Pair[T2, T1]{p.second, p.first}
+}
@@ -0,0 +1,23 @@
+package generic3
+
+type Ordered interface {
+	~int | ~string
+}
+
+func Max[T Ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type Lesser[U any] interface {
+	Less(U) bool
+}
+
+func Min[E Lesser[E]](a, b E) E {
+	if a.Less(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,31 @@
+package foo
+
+import "fmt"
+
+type Node struct {
+	value int
+	next  *Node
+}
+
+type List struct {
+	head *Node
+}
+
+var defaultList = newList()
+
+func newList() *List {
+	return &List{}
+}
+
+func Default() *List {
+	return defaultList
+}
+
+func (l *List) String() string {
+	return fmt.Sprint(l.head.value)
+}
+
+func Print(l *List) {
+	var s fmt.Stringer = l
+	fmt.Println(s)
+}
@@ -0,0 +1,42 @@
+package graph
+
+import "fmt"
+
+type T interface{}
+
+type Node struct {
+	value T
+	next  *Node
+}
+
+type List struct {
+	head *Node
+}
+
+var defaultList = newList()
+
+func newList() *List {
+	return &List{}
+}
+
+func Default() *List {
+	return defaultList
+}
+
+func (l *List) String() string {
+	return fmt.Sprint(l.head.value)
+}
+
+func (l *List) Len() (n int) {
+	for node := l.head; node != nil; node = node.next {
+		n++
+	}
+	return
+}
+
+func Print(l *List) {
+	var s fmt.Stringer = l
+	fmt.Println(s)
+}
+
+func Unused() {}
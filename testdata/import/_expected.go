@@ -1,8 +1,10 @@
 package foo
 
-import "fmt"
+import (
+	"fmt"
 
-import bs "bytes"
+	bs "bytes"
+)
 
 func foo() {
 	fmt.Printf("foo")
@@ -0,0 +1,20 @@
+package manifest
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type Ints []int
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+func NewInts() (ret Ints) {
+	return
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+const foo = 42
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+type Strings []string
+
+//ccg:generated github.com/reusee/ccg/testdata/copy
+func NewStrings() (ret Strings) {
+	return
+}
@@ -0,0 +1,12 @@
+package manifest
+
+//ccg:generated github.com/reusee/ccg/testdata/deps
+type T int
+
+//ccg:generated github.com/reusee/ccg/testdata/deps
+func (t T) Foo() {
+	t.Baz()
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/deps
+func (t *T) Baz() {}
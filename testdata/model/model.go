@@ -0,0 +1,11 @@
+package model
+
+import "strings"
+
+type User struct {
+	Name string
+}
+
+func Compare(a, b User) int {
+	return strings.Compare(a.Name, b.Name)
+}
@@ -1,8 +1,10 @@
 package foo
 
-import "fmt"
+import (
+	"fmt"
 
-import ft "fmt"
+	ft "fmt"
+)
 
 var foo = fmt.Printf
 
@@ -0,0 +1,30 @@
+package foo
+
+import (
+	"io"
+)
+
+type IntSet struct {
+	items []int
+}
+
+func (s *IntSet) Add(v int) {
+	s.items = append(s.items, v)
+}
+
+func (s *IntSet) Len() int {
+	return len(s.items)
+}
+
+type Reader struct {
+	buf []byte
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,20 @@
+package foo
+
+import (
+	"io"
+)
+
+type Reader struct {
+	buf []byte
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func Helper() {}
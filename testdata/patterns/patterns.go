@@ -0,0 +1,43 @@
+package patterns
+
+import (
+	"fmt"
+	"io"
+)
+
+type T interface{}
+
+type Set struct {
+	items []T
+}
+
+func (s *Set) Add(v T) {
+	s.items = append(s.items, v)
+}
+
+func (s *Set) Len() int {
+	return len(s.items)
+}
+
+func (s *Set) Debug() {
+	fmt.Println(s.items)
+}
+
+type Reader struct {
+	buf []byte
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) Reset(buf []byte) {
+	r.buf = buf
+}
+
+func Helper() {}
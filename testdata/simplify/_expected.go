@@ -0,0 +1,19 @@
+package foo
+
+import "fmt"
+
+func Copy(v int) int {
+	// small values are copied directly
+	return v
+}
+
+func Describe(v int) string {
+	{
+		x := any(v)
+		return fmt.Sprint(x)
+	}
+}
+
+func Kind(v int) string {
+	return "int"
+}
@@ -0,0 +1,20 @@
+package foo
+
+func Copy(v string) string {
+	{
+		// large values are copied by pointer
+		p := &v
+		return *p
+	}
+}
+
+func Describe(v string) string {
+	{
+		x := v
+		return x
+	}
+}
+
+func Kind(v string) string {
+	return "other"
+}
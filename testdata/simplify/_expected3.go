@@ -0,0 +1,19 @@
+package out
+
+import "fmt"
+
+func Copy(v MyType) MyType {
+	// small values are copied directly
+	return v
+}
+
+func Describe(v MyType) string {
+	{
+		x := fmt.Stringer(v)
+		return x.String()
+	}
+}
+
+func Kind(v MyType) string {
+	return "other"
+}
@@ -0,0 +1,38 @@
+package out
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func Copy(v MyType) MyType {
+	if unsafe.Sizeof(v) <= 8 {
+		// small values are copied directly
+		return v
+	} else {
+		// large values are copied by pointer
+		p := &v
+		return *p
+	}
+}
+
+func Describe(v MyType) string {
+	switch x := any(v).(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	case int, int64:
+		return fmt.Sprint(x)
+	default:
+		return "unknown"
+	}
+}
+
+func Kind(v MyType) string {
+	switch any(v).(type) {
+	case int:
+		return "int"
+	}
+	return "other"
+}
@@ -0,0 +1,7 @@
+package out
+
+type MyType int
+
+func (m MyType) String() string {
+	return "my"
+}
@@ -0,0 +1,40 @@
+package simplify
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+type T interface{}
+
+func Copy(v T) T {
+	if unsafe.Sizeof(v) <= 8 {
+		// small values are copied directly
+		return v
+	} else {
+		// large values are copied by pointer
+		p := &v
+		return *p
+	}
+}
+
+func Describe(v T) string {
+	switch x := any(v).(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	case int, int64:
+		return fmt.Sprint(x)
+	default:
+		return "unknown"
+	}
+}
+
+func Kind(v T) string {
+	switch any(v).(type) {
+	case int:
+		return "int"
+	}
+	return "other"
+}
@@ -0,0 +1,14 @@
+package out
+
+//ccg:generated github.com/reusee/ccg/testdata/split
+type IntSet map[int]struct{}
+
+//ccg:generated github.com/reusee/ccg/testdata/split
+func NewIntSet() IntSet {
+	return make(IntSet)
+}
+
+//ccg:generated github.com/reusee/ccg/testdata/split
+func (s IntSet) Add(v int) {
+	s[v] = struct{}{}
+}
@@ -0,0 +1,16 @@
+package out
+
+import "iter"
+
+// All iterates elements in the set
+//
+//ccg:generated github.com/reusee/ccg/testdata/split
+func (s IntSet) All() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for v := range s {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
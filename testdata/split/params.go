@@ -0,0 +1,3 @@
+package split
+
+type T interface{}
@@ -0,0 +1,14 @@
+// Copyright 2026 The ccg Authors.
+
+// Package split is a template of sets in multiple files.
+package split
+
+type Set map[T]struct{}
+
+func New() Set {
+	return make(Set)
+}
+
+func (s Set) Add(v T) {
+	s[v] = struct{}{}
+}
@@ -0,0 +1,16 @@
+//go:build go1.23
+
+package split
+
+import "iter"
+
+// All iterates elements in the set
+func (s Set) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range s {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
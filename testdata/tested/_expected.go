@@ -0,0 +1,13 @@
+package out
+
+// Index returns the index of v in s, or -1
+//
+//ccg:generated github.com/reusee/ccg/testdata/tested
+func IndexFloat64(s []float64, v float64) int {
+	for i, e := range s {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
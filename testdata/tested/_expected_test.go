@@ -0,0 +1,11 @@
+package out
+
+import "testing"
+
+//ccg:generated github.com/reusee/ccg/testdata/tested
+func TestIndexFloat64(t *testing.T) {
+	var v float64
+	if i := IndexFloat64([]float64{v}, v); i != 0 {
+		t.Fatalf("got %d", i)
+	}
+}
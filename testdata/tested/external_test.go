@@ -0,0 +1 @@
+package tested_test
@@ -0,0 +1,13 @@
+package tested
+
+type T interface{}
+
+// Index returns the index of v in s, or -1
+func Index(s []T, v T) int {
+	for i, e := range s {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,10 @@
+package tested
+
+import "testing"
+
+func TestIndex(t *testing.T) {
+	var v T
+	if i := Index([]T{v}, v); i != 0 {
+		t.Fatalf("got %d", i)
+	}
+}
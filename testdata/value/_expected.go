@@ -0,0 +1,15 @@
+package foo
+
+type Flag uint8
+
+var Buf [(1 << 20) * 2]byte
+
+var Limit = int64(42)
+
+var Greeting = "hello, " + "say \"hi\" `there`"
+
+var Scale = 0.5 * 2
+
+var Verbose = true
+
+var DefaultMode = Flag(3)
@@ -0,0 +1,27 @@
+package value
+
+type Flag uint8
+
+const Size = 0
+
+const Max int64 = 0
+
+var Name string
+
+var Ratio float64
+
+var Debug bool
+
+var Mode Flag
+
+var Buf [Size * 2]byte
+
+var Limit = Max
+
+var Greeting = "hello, " + Name
+
+var Scale = Ratio * 2
+
+var Verbose = Debug
+
+var DefaultMode = Mode
@@ -2,4 +2,4 @@ package foo
 
 var Num = 42
 
-var Str = `foobar`
+var Str = "foobar"
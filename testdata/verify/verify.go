@@ -0,0 +1,3 @@
+package verify
+
+var _ = NewInts
@@ -0,0 +1,165 @@
+package ccg
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// parseUses returns objects of the template package requested by uses. specs are in forms of
+//
+//	Name              a top-level declaration
+//	Type.Method       a method of a type
+//	Type.*            a type and all its methods
+//	implements:Iface  types of the template implementing the interface, with methods of the interface only
+//	!spec             objects of spec excluded, from all declarations if there are only exclusions
+//
+// names are the renamed ones or the template ones. excluded objects are still generated if others depend on them.
+func parseUses(loader *Loader, pkg *packages.Package, uses []string, renamed map[string]string) (ObjectSet, error) {
+	lookup := func(name string) types.Object {
+		if from, ok := renamed[name]; ok {
+			name = from
+		}
+		return pkg.Types.Scope().Lookup(name)
+	}
+
+	parse := func(use string) ([]types.Object, error) {
+		if iface, ok := strings.CutPrefix(use, "implements:"); ok {
+			return implementing(loader, pkg, iface, lookup)
+		}
+		parts := strings.Split(use, ".")
+		switch len(parts) {
+		case 2: // method
+			typeName, ok := lookup(parts[0]).(*types.TypeName)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a type", parts[0])
+			}
+			if parts[1] == "*" {
+				ret := []types.Object{typeName}
+				mset := types.NewMethodSet(types.NewPointer(typeName.Type()))
+				for i := 0; i < mset.Len(); i++ {
+					if method := mset.At(i).Obj(); method.Pkg() == pkg.Types {
+						ret = append(ret, originOf(method))
+					}
+				}
+				return ret, nil
+			}
+			obj, _, _ := types.LookupFieldOrMethod(typeName.Type(), true, pkg.Types, parts[1])
+			if _, ok := obj.(*types.Func); !ok {
+				return nil, fmt.Errorf("%s has no method %s", parts[0], parts[1])
+			}
+			return []types.Object{originOf(obj)}, nil
+		case 1: // non-method
+			obj := lookup(parts[0])
+			if obj == nil {
+				return nil, fmt.Errorf("%s not found", parts[0])
+			}
+			return []types.Object{obj}, nil
+		}
+		return nil, fmt.Errorf("invalid use spec: %s", use)
+	}
+
+	requested := NewObjectSet()
+	excluded := NewObjectSet()
+	all := true
+	for _, use := range uses {
+		set := requested
+		if spec, ok := strings.CutPrefix(use, "!"); ok {
+			set = excluded
+			use = spec
+		} else {
+			all = false
+		}
+		objs, err := parse(use)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			set.Add(obj)
+		}
+	}
+
+	if all { // exclusions only
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			requested.Add(obj)
+			if named, ok := obj.Type().(*types.Named); ok && isTypeName(obj) {
+				for i := 0; i < named.NumMethods(); i++ {
+					requested.Add(named.Method(i))
+				}
+			}
+		}
+	}
+	for obj := range excluded {
+		delete(requested, obj)
+	}
+	return requested, nil
+}
+
+// implementing returns types of the template implementing the interface named iface, by value or pointer, and their methods of the interface.
+// iface is resolved in the template package, or as a qualified name of an import path.
+func implementing(loader *Loader, pkg *packages.Package, iface string, lookup func(string) types.Object) ([]types.Object, error) {
+	var t types.Type
+	if obj := lookup(iface); obj != nil {
+		t = obj.Type()
+	} else if tv, ok, _ := evalValue(loader.FileSet, pkg, iface); ok && tv.IsType() {
+		t = tv.Type
+	} else if i := strings.LastIndex(iface, "."); i > 0 {
+		p, err := loader.Load(iface[:i])
+		if err != nil {
+			return nil, me(err, "load package of %s", iface)
+		}
+		if obj := p.Types.Scope().Lookup(iface[i+1:]); obj != nil && isTypeName(obj) {
+			t = obj.Type()
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("interface %s not found", iface)
+	}
+	it, ok := t.Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", iface)
+	}
+
+	var ret []types.Object
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		obj, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || obj.IsAlias() || types.IsInterface(obj.Type()) {
+			continue
+		}
+		named, ok := obj.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+		if params := named.TypeParams(); params.Len() > 0 { // instantiated with its own type parameters
+			var args []types.Type
+			for i := 0; i < params.Len(); i++ {
+				args = append(args, params.At(i))
+			}
+			instance, err := types.Instantiate(nil, named, args, false)
+			if err != nil { //NOCOVER
+				continue
+			}
+			named = instance.(*types.Named)
+		}
+		if !types.Implements(named, it) && !types.Implements(types.NewPointer(named), it) {
+			continue
+		}
+		ret = append(ret, obj)
+		for i := 0; i < it.NumMethods(); i++ {
+			method, _, _ := types.LookupFieldOrMethod(named, true, pkg.Types, it.Method(i).Name())
+			if method.Pkg() == pkg.Types && method.Pos() != token.NoPos {
+				ret = append(ret, originOf(method))
+			}
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no type implements %s", iface)
+	}
+	return ret, nil
+}
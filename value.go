@@ -0,0 +1,182 @@
+package ccg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// valueParam returns the expression substituting the const or var placeholder obj with value.
+// values of string placeholders are quoted, others are Go expressions validated against the placeholder type.
+// renames are applied to the placeholder type if converted to.
+func valueParam(fset *token.FileSet, pkg *packages.Package, obj types.Object, value string, renames map[string]string) (string, error) {
+	basic, isBasic := obj.Type().Underlying().(*types.Basic)
+	if isBasic && basic.Info()&types.IsString != 0 {
+		value = strconv.Quote(value)
+	}
+
+	expr, err := parser.ParseExpr(value)
+	if err != nil {
+		return "", me(err, "invalid value %s", value)
+	}
+	buf := new(bytes.Buffer)
+	if err := format.Node(buf, token.NewFileSet(), expr); err != nil { //NOCOVER
+		return "", me(err, "format value %s", value)
+	}
+	formatted := buf.String()
+	value = formatted
+	switch expr.(type) {
+	case *ast.BasicLit, *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.ParenExpr, *ast.IndexExpr, *ast.CompositeLit:
+	default:
+		value = "(" + value + ")"
+	}
+
+	if !isBasic {
+		return value, nil
+	}
+	tv, ok, err := evalValue(fset, pkg, value)
+	if err != nil {
+		return "", me(err, "invalid value %s", value)
+	}
+	if !ok {
+		return value, nil
+	}
+	if _, ok := obj.(*types.Const); ok && tv.Value == nil {
+		return "", me(nil, "%s is not a constant", value)
+	}
+	if basic.Info()&types.IsUntyped != 0 {
+		// of the same kind as the placeholder value, numeric kinds are interchangeable
+		if c, ok := obj.(*types.Const); ok {
+			if want := kindName(c.Val().Kind()); kindName(tv.Value.Kind()) != want {
+				return "", me(nil, "%s is not a %s constant", value, want)
+			}
+		}
+		return value, nil
+	}
+	if !types.AssignableTo(tv.Type, obj.Type()) {
+		return "", me(nil, "%s of type %s is not assignable to %s", value, tv.Type, obj.Type())
+	}
+	if tv.Value == nil || types.Identical(types.Default(tv.Type), obj.Type()) {
+		return value, nil
+	}
+	// convert untyped constants to keep the placeholder type, checking representability
+	typeStr := types.TypeString(obj.Type(), func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		return p.Name()
+	})
+	if _, err := types.Eval(fset, pkg.Types, token.NoPos, typeStr+"("+formatted+")"); err != nil {
+		return "", me(err, "invalid value %s", value)
+	}
+	if to, ok := renames[typeStr]; ok {
+		typeStr = to
+	}
+	return typeStr + "(" + formatted + ")", nil
+}
+
+// kindName returns the name of the kind of constants, numeric kinds are not distinguished
+func kindName(kind constant.Kind) string {
+	switch kind {
+	case constant.Bool:
+		return "boolean"
+	case constant.String:
+		return "string"
+	case constant.Int, constant.Float, constant.Complex:
+		return "numeric"
+	}
+	return "unknown" //NOCOVER
+}
+
+// isTypeName reports whether obj is a type
+func isTypeName(obj types.Object) bool {
+	_, ok := obj.(*types.TypeName)
+	return ok
+}
+
+// isFuncParam reports whether obj is a function placeholder, a function or a variable of function type
+func isFuncParam(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return true
+	case *types.Var:
+		_, ok := obj.Type().Underlying().(*types.Signature)
+		return ok
+	}
+	return false
+}
+
+// funcParam returns the function reference substituting the function placeholder obj.
+// the signature is checked if value and types bound to placeholders are resolvable in the template package.
+func funcParam(fset *token.FileSet, pkg *packages.Package, obj types.Object, value string, params map[string]string) (string, error) {
+	expr, err := parser.ParseExpr(value)
+	if err != nil {
+		return "", me(err, "invalid function %s", value)
+	}
+	switch expr.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+	default:
+		return "", me(nil, "%s is not a function name", value)
+	}
+	want := obj.Type().Underlying().(*types.Signature)
+	if want.TypeParams().Len() > 0 {
+		return value, nil
+	}
+	tv, ok, err := evalValue(fset, pkg, value)
+	if err != nil {
+		return "", me(err, "invalid function %s", value)
+	}
+	if !ok {
+		return value, nil
+	}
+	got, isFunc := tv.Type.Underlying().(*types.Signature)
+	if !isFunc || !tv.IsValue() {
+		return "", me(nil, "%s is not a function", value)
+	}
+	bound := make(map[types.Object]types.Type)
+	for name, to := range params {
+		placeholder, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		t, ok, err := evalValue(fset, pkg, to)
+		if err != nil || !ok || !t.IsType() {
+			return value, nil
+		}
+		bound[placeholder] = t.Type
+	}
+	want = substType(want, bound).(*types.Signature)
+	qualifier := types.RelativeTo(pkg.Types)
+	if !types.Identical(want, got) {
+		return "", me(nil, "wrong signature of %s: have %s, want %s", value,
+			types.TypeString(got, qualifier), types.TypeString(want, qualifier))
+	}
+	return value, nil
+}
+
+// evalValue evaluates expr in the template package scope, or file scopes for imported names.
+// ok is false if expr references names undefined in the template, which are left to verification.
+func evalValue(fset *token.FileSet, pkg *packages.Package, expr string) (tv types.TypeAndValue, ok bool, err error) {
+	positions := []token.Pos{token.NoPos}
+	for _, f := range pkg.Syntax {
+		positions = append(positions, f.Name.End())
+	}
+	for _, pos := range positions {
+		tv, err = types.Eval(fset, pkg.Types, pos, expr)
+		if err == nil {
+			return tv, true, nil
+		}
+		if e, isTypeErr := err.(types.Error); !isTypeErr || !strings.HasPrefix(e.Msg, "undefined:") {
+			return tv, false, err
+		}
+	}
+	return tv, false, nil
+}
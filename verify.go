@@ -0,0 +1,196 @@
+package ccg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Diagnostic is a type-checking error found in the generated output
+type Diagnostic struct {
+	Pos      token.Position // position in the output file
+	Template token.Position // corresponding position in the template package, invalid if not generated from template
+	Msg      string
+}
+
+func (d Diagnostic) String() string {
+	if d.Template.IsValid() {
+		return sp("%v: %s (generated at %v)", d.Template, d.Msg, d.Pos)
+	}
+	return sp("%v: %s", d.Pos, d.Msg)
+}
+
+// VerifyError reports the generated output failed to type-check with the destination package
+type VerifyError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *VerifyError) Error() string {
+	lines := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// declPositions returns the position of each top-level name declared by decl
+func declPositions(fset *token.FileSet, decl ast.Decl) map[string]token.Position {
+	ret := make(map[string]token.Position)
+	for name, node := range declNodes(decl) {
+		ret[name] = fset.Position(node.Pos())
+	}
+	return ret
+}
+
+// declNodes returns the declaration or spec of each top-level name declared by decl
+func declNodes(decl ast.Decl) map[string]ast.Node {
+	ret := make(map[string]ast.Node)
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		ret[getFuncDeclName(decl)] = decl
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				ret[spec.Name.Name] = spec
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					ret[name.Name] = spec
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// verifyOutput type-checks src as the content of outputFile together with the other files of its package.
+// origins maps generated declaration names to their positions in the template package.
+func verifyOutput(outputFile string, src []byte, origins map[string]token.Position) error {
+	return verifyOutputs(map[string][]byte{
+		outputFile: src,
+	}, map[string]map[string]token.Position{
+		outputFile: origins,
+	})
+}
+
+// verifyOutputs type-checks contents of output files together with the other files of their packages.
+// origins maps output files to positions of generated declarations in template packages by name.
+func verifyOutputs(outputs map[string][]byte, origins map[string]map[string]token.Position) error {
+	// group by package directory
+	overlays := make(map[string]map[string][]byte)
+	outputOrigins := make(map[string]map[string]token.Position)
+	var dirs []string
+	for outputFile, src := range outputs {
+		path, err := filepath.Abs(outputFile)
+		if err != nil { //NOCOVER
+			return err
+		}
+		dir := filepath.Dir(path)
+		if _, ok := overlays[dir]; !ok {
+			overlays[dir] = make(map[string][]byte)
+			dirs = append(dirs, dir)
+		}
+		overlays[dir][path] = src
+		outputOrigins[path] = origins[outputFile]
+	}
+	sort.Strings(dirs)
+
+	verifyErr := new(VerifyError)
+	for _, dir := range dirs {
+		diagnostics, err := verifyPackage(dir, overlays[dir], outputOrigins)
+		if err != nil {
+			return err
+		}
+		verifyErr.Diagnostics = append(verifyErr.Diagnostics, diagnostics...)
+	}
+	if len(verifyErr.Diagnostics) > 0 {
+		return verifyErr
+	}
+	return nil
+}
+
+// verifyPackage type-checks the package in dir with overlay, returning diagnostics mapped to the template by origins of output files
+func verifyPackage(dir string, overlay map[string][]byte, origins map[string]map[string]token.Position) ([]Diagnostic, error) {
+	tests := false // test files are checked with the package under test
+	for path := range overlay {
+		tests = tests || strings.HasSuffix(path, "_test.go")
+	}
+	fset := new(token.FileSet)
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports |
+			packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Dir:     dir,
+		Fset:    fset,
+		Overlay: overlay,
+		Tests:   tests,
+	}, ".")
+	if err != nil {
+		return nil, err
+	}
+	if tests {
+		pkgs = testVariants(pkgs)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s contains %d packages", dir, len(pkgs))
+	}
+	pkg := pkgs[0]
+
+	// map a position in an output file to the template
+	outputs := make(map[string]*ast.File)
+	for _, f := range pkg.Syntax {
+		path := fset.Position(f.Pos()).Filename
+		if _, ok := overlay[path]; ok {
+			outputs[path] = f
+		}
+	}
+	mapPosition := func(pos token.Position) token.Position {
+		output, ok := outputs[pos.Filename]
+		if !ok {
+			return token.Position{}
+		}
+		for _, decl := range output.Decls {
+			if pos.Line < fset.Position(decl.Pos()).Line || pos.Line > fset.Position(decl.End()).Line {
+				continue
+			}
+			// the nearest spec starting before pos
+			var ret token.Position
+			line := 0
+			for name, declPos := range declPositions(fset, decl) {
+				origin, ok := origins[pos.Filename][name]
+				if !ok || declPos.Line > pos.Line || declPos.Line < line {
+					continue
+				}
+				line = declPos.Line
+				ret = origin
+				ret.Line += pos.Line - declPos.Line
+				ret.Column = 0
+			}
+			return ret
+		}
+		return token.Position{}
+	}
+
+	var diagnostics []Diagnostic
+	if len(pkg.TypeErrors) > 0 {
+		for _, e := range pkg.TypeErrors {
+			pos := e.Fset.Position(e.Pos)
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:      pos,
+				Template: mapPosition(pos),
+				Msg:      e.Msg,
+			})
+		}
+	} else {
+		for _, e := range pkg.Errors {
+			diagnostics = append(diagnostics, Diagnostic{
+				Msg: e.Error(),
+			})
+		}
+	}
+	return diagnostics, nil
+}